@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	. "pppoe-sim/pppoe"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Exit codes of the non-interactive mode.
+const (
+	exitCaptured       = 0
+	exitError          = 1
+	exitTimeout        = 2
+	exitInterfaceError = 3
+	exitUsage          = 4
+)
+
+// Output formats of the captured credentials.
+const (
+	formatText = "text"
+	formatHash = "hash"
+	formatJSON = "json"
+)
+
+// options holds the settings of the command line. base carries the protocol
+// settings of the configuration file.
+type options struct {
+	base             Config
+	backend          string
+	record           string
+	recordPerSession bool
+	once             bool
+	timeout          time.Duration
+	format           string
+	logFormat        string
+	logOutput        io.Writer
+	hashFile         string
+}
+
+func (o *options) config(iface *Interface) Config {
+	config := o.base
+	config.Interface = iface
+	config.Backend = o.backend
+	if config.AuthPreference == nil {
+		config.AuthPreference = DefaultAuthPreference
+	}
+	config.RecordFile = o.record
+	config.RecordPerSession = o.recordPerSession
+	config.LogOutput = o.logOutput
+	config.LogFormat = o.logFormat
+	return config
+}
+
+// findInterface selects an interface by name, MAC address or the index
+// shown by printInterfaces.
+func findInterface(interfaces []*Interface, spec string) *Interface {
+	for _, iface := range interfaces {
+		if iface.Name == spec {
+			return iface
+		}
+	}
+	if mac, err := net.ParseMAC(spec); err == nil {
+		for _, iface := range interfaces {
+			if iface.HardwareAddr.String() == mac.String() {
+				return iface
+			}
+		}
+	}
+	if idx, err := strconv.Atoi(spec); err == nil && idx > 0 && idx <= len(interfaces) {
+		return interfaces[idx-1]
+	}
+	return nil
+}
+
+type credentialJSON struct {
+	Protocol string `json:"protocol"`
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// printCredentials prints the captured credentials in the chosen format and
+// appends their hashes to hashFile.
+func printCredentials(format string, hashFile string, credentials []*Credential) {
+	for _, credential := range credentials {
+		switch format {
+		case formatHash:
+			if credential.Protocol == PPPTypePasswordAuthentication {
+				fmt.Printf("%s:%s\n", credential.Username, credential.Password)
+			} else if hash := credential.Hash(); hash != "" {
+				fmt.Println(hash)
+			}
+		case formatJSON:
+			data, _ := json.Marshal(&credentialJSON{
+				Protocol: credential.AuthProtocol().String(),
+				Username: credential.Username,
+				Password: credential.Password,
+				Hash:     credential.Hash(),
+			})
+			fmt.Println(string(data))
+		default:
+			printCredential(credential)
+		}
+		if err := appendHash(hashFile, credential); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		}
+	}
+}
+
+// runNonInteractive serves the interface given on the command line until a
+// credential is captured (with -once), the timeout expires or the process is
+// interrupted, and returns the exit code. The packet trace goes to stderr so
+// that stdout only carries the credentials.
+func runNonInteractive(spec string, opts *options) int {
+	interfaces, err := GetActiveInterfaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	iface := findInterface(interfaces, spec)
+	if iface == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: interface %s not found\n", spec)
+		return exitInterfaceError
+	}
+	config := opts.config(iface)
+	if config.LogOutput == nil {
+		config.LogOutput = os.Stderr
+	}
+	ctx, cancel := notifyContext(opts.timeout)
+	defer cancel()
+	events, err := Serve(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	return collectCredentials(ctx, cancel, events, opts)
+}
+
+// notifyContext returns a context cancelled when the process is interrupted
+// or, if timeout is not 0, when it expires.
+func notifyContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		cancelSignal := cancel
+		cancel = func() {
+			cancelTimeout()
+			cancelSignal()
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+// collectCredentials gathers the credentials of events until the channel is
+// closed, cancelling ctx on the first one with -once, prints them and
+// returns the exit code.
+func collectCredentials(ctx context.Context, cancel context.CancelFunc, events <-chan Event, opts *options) int {
+	credentials := make([]*Credential, 0)
+	for event := range events {
+		if event.Credential != nil {
+			credentials = append(credentials, event.Credential)
+			if opts.once {
+				cancel()
+			}
+		}
+	}
+
+	printCredentials(opts.format, opts.hashFile, credentials)
+	switch {
+	case len(credentials) > 0:
+		return exitCaptured
+	case ctx.Err() == context.DeadlineExceeded:
+		return exitTimeout
+	}
+	return exitError
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	. "pppoe-sim/pppoe"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// duration is a time.Duration written as a string such as "30s" in the
+// configuration file.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+func (d duration) String() string {
+	if d == 0 {
+		return ""
+	}
+	return time.Duration(d).String()
+}
+
+// fileConfig is the JSON configuration file given with -config. Settings
+// given on the command line take precedence over the file.
+type fileConfig struct {
+	Interface      string   `json:"interface"`
+	Backend        string   `json:"backend"`
+	ACName         string   `json:"ac_name"`
+	ServiceNames   []string `json:"service_names"`
+	ServicePolicy  string   `json:"service_policy"`
+	IgnoreMACs     []string `json:"ignore_macs"`
+	MaxSessions    int      `json:"max_sessions"`
+	CookieLength   int      `json:"cookie_length"`
+	CookieLifetime duration `json:"cookie_lifetime"`
+	MRU            uint16   `json:"mru"`
+	Auth           []string `json:"auth"`
+	Timers         struct {
+		Restart      duration `json:"restart"`
+		MaxConfigure int      `json:"max_configure"`
+		MaxTerminate int      `json:"max_terminate"`
+		MaxFailure   int      `json:"max_failure"`
+	} `json:"timers"`
+	RADIUS struct {
+		Server        string   `json:"server"`
+		Secret        string   `json:"secret"`
+		NASIdentifier string   `json:"nas_identifier"`
+		Timeout       duration `json:"timeout"`
+		Retries       int      `json:"retries"`
+	} `json:"radius"`
+	Users struct {
+		File           string `json:"file"`
+		RejectMessage  string `json:"reject_message"`
+		BindingMessage string `json:"binding_message"`
+	} `json:"users"`
+	IPCP struct {
+		LocalAddress string `json:"local_address"`
+		PoolStart    string `json:"pool_start"`
+		PoolEnd      string `json:"pool_end"`
+		PrimaryDNS   string `json:"primary_dns"`
+		SecondaryDNS string `json:"secondary_dns"`
+	} `json:"ipcp"`
+	Termination struct {
+		HoldTime           duration `json:"hold_time"`
+		TerminateOnCapture bool     `json:"terminate_on_capture"`
+		ExitOnCapture      bool     `json:"exit_on_capture"`
+		Timeout            duration `json:"timeout"`
+	} `json:"termination"`
+	Output struct {
+		Format           string `json:"format"`
+		LogFormat        string `json:"log_format"`
+		LogFile          string `json:"log_file"`
+		HashFile         string `json:"hash_file"`
+		RecordFile       string `json:"record_file"`
+		RecordPerSession bool   `json:"record_per_session"`
+	} `json:"output"`
+}
+
+func loadConfigFile(path string) (*fileConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	config := &fileConfig{}
+	if err = decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return config, nil
+}
+
+// flagValues returns the settings of the file that can also be given on the
+// command line, keyed by flag name. Unset settings are omitted.
+func (f *fileConfig) flagValues() map[string]string {
+	values := map[string]string{
+		"interface":  f.Interface,
+		"backend":    f.Backend,
+		"timeout":    f.Termination.Timeout.String(),
+		"format":     f.Output.Format,
+		"log-format": f.Output.LogFormat,
+		"log-file":   f.Output.LogFile,
+		"hash-file":  f.Output.HashFile,
+		"record":     f.Output.RecordFile,
+	}
+	if f.Termination.ExitOnCapture {
+		values["once"] = strconv.FormatBool(true)
+	}
+	if f.Output.RecordPerSession {
+		values["record-per-session"] = strconv.FormatBool(true)
+	}
+	for name, value := range values {
+		if value == "" {
+			delete(values, name)
+		}
+	}
+	return values
+}
+
+// apply sets the protocol settings of the file on config.
+func (f *fileConfig) apply(config *Config) error {
+	config.ACName = f.ACName
+	config.ServiceNames = f.ServiceNames
+	policy, ok := ParseServicePolicy(f.ServicePolicy)
+	if f.ServicePolicy != "" && !ok {
+		return fmt.Errorf("unknown service policy %s", f.ServicePolicy)
+	}
+	config.ServicePolicy = policy
+	config.IgnoreMACs = nil
+	for _, s := range f.IgnoreMACs {
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return fmt.Errorf("invalid ignore_macs entry %s", s)
+		}
+		config.IgnoreMACs = append(config.IgnoreMACs, mac)
+	}
+	config.MaxSessions = f.MaxSessions
+	config.CookieLength = f.CookieLength
+	if f.CookieLength < 0 || f.CookieLength > MaxCookieLength {
+		return fmt.Errorf("cookie_length must be between 0 and %d", MaxCookieLength)
+	}
+	config.CookieLifetime = time.Duration(f.CookieLifetime)
+	config.MRU = f.MRU
+	if f.MRU != 0 && (f.MRU < MinMRU || f.MRU > DefaultMRU) {
+		return fmt.Errorf("mru must be between %d and %d", MinMRU, DefaultMRU)
+	}
+	config.AuthPreference = nil
+	for _, name := range f.Auth {
+		auth, ok := ParseAuthName(name)
+		if !ok {
+			return fmt.Errorf("unknown authentication protocol %s", name)
+		}
+		config.AuthPreference = append(config.AuthPreference, auth)
+	}
+	config.Authenticator = nil
+	if f.RADIUS.Server != "" && f.Users.File != "" {
+		return fmt.Errorf("radius and users cannot be used together")
+	}
+	if f.Users.File != "" {
+		users, err := readUsers(f.Users.File)
+		if err != nil {
+			return err
+		}
+		database := NewUserDatabase(users)
+		if f.Users.RejectMessage != "" {
+			database.RejectMessage = f.Users.RejectMessage
+		}
+		if f.Users.BindingMessage != "" {
+			database.BindingMessage = f.Users.BindingMessage
+		}
+		config.Authenticator = database
+	}
+	if f.RADIUS.Server != "" {
+		config.Authenticator = &RADIUSAuthenticator{
+			Address:       f.RADIUS.Server,
+			Secret:        f.RADIUS.Secret,
+			NASIdentifier: f.RADIUS.NASIdentifier,
+			Timeout:       time.Duration(f.RADIUS.Timeout),
+			Retries:       f.RADIUS.Retries,
+		}
+	}
+	ipcp, err := f.ipcpConfig()
+	if err != nil {
+		return err
+	}
+	config.IPCP = ipcp
+	config.RestartTimer = time.Duration(f.Timers.Restart)
+	config.MaxConfigure = f.Timers.MaxConfigure
+	config.MaxTerminate = f.Timers.MaxTerminate
+	config.MaxFailure = f.Timers.MaxFailure
+	config.SessionHoldTime = time.Duration(f.Termination.HoldTime)
+	config.TerminateOnCapture = f.Termination.TerminateOnCapture
+	return nil
+}
+
+// readUsers reads the accounts of the users file, one per line with the
+// columns "username password [mac] [address]". A - leaves the MAC address
+// unbound, and blank lines and lines starting with # are ignored.
+func readUsers(path string) ([]*User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	users := make([]*User, 0)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected username, password, MAC address and IP address", path, line)
+		}
+		user := &User{Username: fields[0], Password: fields[1]}
+		if len(fields) > 2 && fields[2] != "-" {
+			if user.HardwareAddr, err = net.ParseMAC(fields[2]); err != nil {
+				return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+			}
+		}
+		if len(fields) > 3 {
+			if user.FramedAddress = net.ParseIP(fields[3]).To4(); user.FramedAddress == nil {
+				return nil, fmt.Errorf("%s:%d: invalid IP address %s", path, line, fields[3])
+			}
+		}
+		users = append(users, user)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ipcpConfig returns the IPCP settings of the file, nil if none is set.
+// Unset addresses keep the value of NewDefaultIPCPConfig.
+func (f *fileConfig) ipcpConfig() (*IPCPConfig, error) {
+	settings := &f.IPCP
+	if *settings == (fileConfig{}).IPCP {
+		return nil, nil
+	}
+	config := NewDefaultIPCPConfig()
+	addresses := []struct {
+		name  string
+		value string
+		ip    *net.IP
+	}{
+		{"local_address", settings.LocalAddress, &config.LocalAddress},
+		{"primary_dns", settings.PrimaryDNS, &config.PrimaryDNS},
+		{"secondary_dns", settings.SecondaryDNS, &config.SecondaryDNS},
+	}
+	for _, address := range addresses {
+		if address.value == "" {
+			continue
+		}
+		ip := net.ParseIP(address.value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("ipcp: invalid %s %s", address.name, address.value)
+		}
+		*address.ip = ip
+	}
+	if settings.PoolStart != "" || settings.PoolEnd != "" {
+		pool, err := NewAddressPool(net.ParseIP(settings.PoolStart), net.ParseIP(settings.PoolEnd))
+		if err != nil {
+			return nil, fmt.Errorf("ipcp: invalid pool %s - %s", settings.PoolStart, settings.PoolEnd)
+		}
+		config.Pool = pool
+	}
+	return config, nil
+}
+
+// loadOptions reads the configuration file at path into opts. Flags given on
+// the command line keep their value.
+func loadOptions(path string, opts *options) error {
+	file, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	if err = file.apply(&opts.base); err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	// -i is a shorthand of -interface.
+	if set["i"] {
+		set["interface"] = true
+	}
+	for name, value := range file.flagValues() {
+		if set[name] {
+			continue
+		}
+		if err = flag.Set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %s", path, name, err)
+		}
+	}
+	return nil
+}
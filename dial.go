@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	. "pppoe-sim/pppoe"
+)
+
+type clientSessionJSON struct {
+	AC           string `json:"ac"`
+	ACName       string `json:"ac_name"`
+	SessionID    uint16 `json:"session_id"`
+	AuthProtocol string `json:"auth_protocol,omitempty"`
+	LocalAddress string `json:"local_address"`
+	PeerAddress  string `json:"peer_address"`
+	PrimaryDNS   string `json:"primary_dns,omitempty"`
+	SecondaryDNS string `json:"secondary_dns,omitempty"`
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func printClientSession(format string, session *ClientSession) {
+	if format == formatJSON {
+		s := &clientSessionJSON{
+			AC:           session.AC.String(),
+			ACName:       session.ACName,
+			SessionID:    session.ID,
+			LocalAddress: ipString(session.LocalAddress),
+			PeerAddress:  ipString(session.PeerAddress),
+			PrimaryDNS:   ipString(session.PrimaryDNS),
+			SecondaryDNS: ipString(session.SecondaryDNS),
+		}
+		if session.AuthProtocol.Type != 0 {
+			s.AuthProtocol = session.AuthProtocol.String()
+		}
+		data, _ := json.Marshal(s)
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Printf("已连接 AC %s %s    会话 %d\n", session.AC, session.ACName, session.ID)
+	if session.AuthProtocol.Type != 0 {
+		fmt.Printf("认证方式 %s\n", session.AuthProtocol)
+	}
+	fmt.Printf("本地地址 %s    对端地址 %s\n", session.LocalAddress, session.PeerAddress)
+	if session.PrimaryDNS != nil {
+		fmt.Printf("DNS %s %s\n", session.PrimaryDNS, session.SecondaryDNS)
+	}
+}
+
+// runDial dials a session like a CPE on the interface given on the command
+// line and keeps it up until the timeout expires or the process is
+// interrupted. It returns exitCaptured once connected, exitTimeout if the
+// timeout expired before and exitError if the session was refused.
+func runDial(spec string, opts *options, username string, password string, service string) int {
+	interfaces, err := GetActiveInterfaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	iface := findInterface(interfaces, spec)
+	if iface == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: interface %s not found\n", spec)
+		return exitInterfaceError
+	}
+	config := ClientConfig{
+		Interface:    iface,
+		Backend:      opts.backend,
+		ServiceName:  service,
+		Username:     username,
+		Password:     password,
+		MRU:          opts.base.MRU,
+		RestartTimer: opts.base.RestartTimer,
+		MaxConfigure: opts.base.MaxConfigure,
+		MaxTerminate: opts.base.MaxTerminate,
+		MaxFailure:   opts.base.MaxFailure,
+		LogOutput:    opts.logOutput,
+		LogFormat:    opts.logFormat,
+	}
+	if config.LogOutput == nil {
+		config.LogOutput = os.Stderr
+	}
+	ctx, cancel := notifyContext(opts.timeout)
+	defer cancel()
+	client, session, err := Dial(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		if err == context.DeadlineExceeded {
+			return exitTimeout
+		}
+		return exitError
+	}
+	printClientSession(opts.format, session)
+	if opts.once {
+		client.Close()
+		return exitCaptured
+	}
+	go func() {
+		<-ctx.Done()
+		client.Close()
+	}()
+	if err = client.Wait(); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+	}
+	return exitCaptured
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	. "pppoe-sim/pppoe"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// loadSettings holds the settings of the load mode given on the command
+// line.
+type loadSettings struct {
+	subscribers string
+	count       int
+	rate        float64
+	concurrency int
+	hold        time.Duration
+	baseMAC     string
+	service     string
+}
+
+// readSubscribers reads a file with one "username password" account per
+// line. Further columns, blank lines and lines starting with # are ignored.
+func readSubscribers(path string) ([]Subscriber, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	subscribers := make([]Subscriber, 0)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: missing password", path, line)
+		}
+		subscribers = append(subscribers, Subscriber{Username: fields[0], Password: fields[1]})
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
+
+type histogramBucketJSON struct {
+	// LE is the upper bound of the bucket in milliseconds, 0 for the last
+	// bucket.
+	LE    float64 `json:"le_ms"`
+	Count int     `json:"count"`
+}
+
+type phaseJSON struct {
+	Count   int                   `json:"count"`
+	Mean    float64               `json:"mean_ms"`
+	Min     float64               `json:"min_ms"`
+	P50     float64               `json:"p50_ms"`
+	P90     float64               `json:"p90_ms"`
+	P99     float64               `json:"p99_ms"`
+	Max     float64               `json:"max_ms"`
+	Buckets []histogramBucketJSON `json:"buckets"`
+}
+
+type loadReportJSON struct {
+	Started   int                   `json:"started"`
+	Connected int                   `json:"connected"`
+	Failed    int                   `json:"failed"`
+	Dropped   int                   `json:"dropped"`
+	Duration  float64               `json:"duration_s"`
+	Phases    map[string]*phaseJSON `json:"phases"`
+	Failures  map[string]int        `json:"failures"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func printLoadReport(format string, report *LoadReport) {
+	if format == formatJSON {
+		r := &loadReportJSON{
+			Started:   report.Started,
+			Connected: report.Connected,
+			Failed:    report.Failed,
+			Dropped:   report.Dropped,
+			Duration:  report.Duration.Seconds(),
+			Phases:    make(map[string]*phaseJSON),
+			Failures:  report.Failures,
+		}
+		for name, h := range report.Phases {
+			p := &phaseJSON{
+				Count: h.Count,
+				Mean:  milliseconds(h.Mean()),
+				Min:   milliseconds(h.Min),
+				P50:   milliseconds(h.Percentile(50)),
+				P90:   milliseconds(h.Percentile(90)),
+				P99:   milliseconds(h.Percentile(99)),
+				Max:   milliseconds(h.Max),
+			}
+			for i, n := range h.Buckets {
+				bucket := histogramBucketJSON{Count: n}
+				if i < len(LatencyBuckets) {
+					bucket.LE = milliseconds(LatencyBuckets[i])
+				}
+				p.Buckets = append(p.Buckets, bucket)
+			}
+			r.Phases[name] = p
+		}
+		data, _ := json.Marshal(r)
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println()
+	fmt.Printf("启动 %d    已连接 %d    失败 %d    保持期间被断开 %d    用时 %s\n",
+		report.Started, report.Connected, report.Failed, report.Dropped, report.Duration.Round(time.Millisecond))
+	for _, name := range LoadPhases {
+		h := report.Phases[name]
+		fmt.Println()
+		if h.Count == 0 {
+			fmt.Printf("%s: 无\n", name)
+			continue
+		}
+		fmt.Printf("%s: 数量 %d    平均 %s    最小 %s    P50 %s    P90 %s    P99 %s    最大 %s\n",
+			name, h.Count, h.Mean().Round(time.Microsecond), h.Min.Round(time.Microsecond),
+			h.Percentile(50).Round(time.Microsecond), h.Percentile(90).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond), h.Max.Round(time.Microsecond))
+		for i, n := range h.Buckets {
+			if n == 0 {
+				continue
+			}
+			bound := "> " + LatencyBuckets[len(LatencyBuckets)-1].String()
+			if i < len(LatencyBuckets) {
+				bound = "<= " + LatencyBuckets[i].String()
+			}
+			fmt.Printf("  %-8s %7d  %s\n", bound, n, strings.Repeat("#", (n*40+h.Count-1)/h.Count))
+		}
+	}
+	if len(report.Failures) > 0 {
+		fmt.Println()
+		fmt.Println("失败原因:")
+		reasons := make([]string, 0, len(report.Failures))
+		for reason := range report.Failures {
+			reasons = append(reasons, reason)
+		}
+		sort.Slice(reasons, func(i, j int) bool {
+			return report.Failures[reasons[i]] > report.Failures[reasons[j]]
+		})
+		for _, reason := range reasons {
+			fmt.Printf("  %7d  %s\n", report.Failures[reason], reason)
+		}
+	}
+}
+
+// runLoad emulates the subscribers of the file given with -load dialing on
+// the interface given on the command line, and prints the report once all
+// of them have ended. It returns exitCaptured if every client connected,
+// exitTimeout if the timeout expired and exitError otherwise.
+func runLoad(spec string, opts *options, load *loadSettings) int {
+	subscribers, err := readSubscribers(load.subscribers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitError
+	}
+	interfaces, err := GetActiveInterfaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	iface := findInterface(interfaces, spec)
+	if iface == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: interface %s not found\n", spec)
+		return exitInterfaceError
+	}
+	config := LoadConfig{
+		Interface: iface,
+		Backend:   opts.backend,
+		Client: ClientConfig{
+			ServiceName:  load.service,
+			MRU:          opts.base.MRU,
+			RestartTimer: opts.base.RestartTimer,
+			MaxConfigure: opts.base.MaxConfigure,
+			MaxTerminate: opts.base.MaxTerminate,
+			MaxFailure:   opts.base.MaxFailure,
+			LogOutput:    opts.logOutput,
+			LogFormat:    opts.logFormat,
+		},
+		Subscribers: subscribers,
+		Count:       load.count,
+		Rate:        load.rate,
+		Concurrency: load.concurrency,
+		HoldTime:    load.hold,
+	}
+	if load.baseMAC != "" {
+		if config.BaseMAC, err = net.ParseMAC(load.baseMAC); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			return exitError
+		}
+	}
+	var connected, failed int32
+	config.OnResult = func(result *LoadResult) {
+		if result.Err != nil {
+			atomic.AddInt32(&failed, 1)
+		} else {
+			atomic.AddInt32(&connected, 1)
+		}
+	}
+	ctx, cancel := notifyContext(opts.timeout)
+	defer cancel()
+	progress := time.NewTicker(time.Second)
+	defer progress.Stop()
+	go func() {
+		for range progress.C {
+			fmt.Fprintf(os.Stderr, "已连接 %d    失败 %d\n", atomic.LoadInt32(&connected), atomic.LoadInt32(&failed))
+		}
+	}()
+	report, err := RunLoad(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitError
+	}
+	printLoadReport(opts.format, report)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return exitTimeout
+	case report.Failed > 0:
+		return exitError
+	}
+	return exitCaptured
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"github.com/rakyll/statik/fs"
 	"io"
@@ -24,6 +25,82 @@ func printInterfaces(interfaces []*Interface) {
 	}
 }
 
+func printCredential(credential *Credential) {
+	lines := []string{fmt.Sprintf("用户名: %s", credential.Username)}
+	if credential.Protocol == PPPTypePasswordAuthentication {
+		lines = append(lines, fmt.Sprintf("密码: %s", credential.Password))
+	} else if hash := credential.Hash(); hash != "" {
+		lines = append(lines, fmt.Sprintf("哈希: %s", hash))
+	}
+	maxLen := 0
+	for _, line := range lines {
+		if len(line) > maxLen {
+			maxLen = len(line)
+		}
+	}
+	separator := strings.Repeat("=", maxLen)
+	fmt.Println()
+	fmt.Println(separator)
+	fmt.Println()
+	fmt.Println("PPPoE 认证信息")
+	fmt.Println()
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	fmt.Println()
+	fmt.Println(separator)
+}
+
+const defaultHashFile = "hashes.txt"
+
+func appendHash(name string, credential *Credential) error {
+	hash := credential.Hash()
+	if hash == "" {
+		return nil
+	}
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, hash)
+	return err
+}
+
+func printCapturedSession(session *CapturedSession) {
+	fmt.Printf("会话 %d    客户端 %s    AC %s %s\n", session.ID, session.Client, session.AC, session.ACName)
+	result := "失败"
+	if session.Authenticated {
+		result = "成功"
+	}
+	if session.AuthProtocol.Type != 0 {
+		fmt.Printf("认证方式 %s    结果 %s\n", session.AuthProtocol, result)
+	} else {
+		fmt.Printf("结果 %s\n", result)
+	}
+	for _, credential := range session.Credentials {
+		printCredential(credential)
+	}
+}
+
+// readCapture lists the credentials found in a capture file instead of
+// serving a live interface.
+func readCapture(path string, hashFile string) {
+	sessions, err := ReadCapture(path)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err)
+	}
+	for _, session := range sessions {
+		fmt.Println()
+		printCapturedSession(session)
+		for _, credential := range session.Credentials {
+			if err = appendHash(hashFile, credential); err != nil {
+				fmt.Printf("ERROR: %s\n", err)
+			}
+		}
+	}
+}
+
 func installPcap() error {
 	statikFS, err := fs.New()
 	if err != nil {
@@ -61,7 +138,117 @@ func installPcap() error {
 }
 
 func main() {
+	opts := &options{}
+	flag.StringVar(&opts.backend, "backend", DefaultBackend(), fmt.Sprintf("抓包方式 (%s)", strings.Join(Backends(), ", ")))
+	capture := flag.String("read", "", "从 pcap/pcapng 文件中读取认证信息")
+	flag.StringVar(&opts.record, "record", "", "将收发的 PPPoE 报文保存到 pcap/pcapng 文件")
+	flag.BoolVar(&opts.recordPerSession, "record-per-session", false, "每个会话保存为单独的文件")
+	ifaceSpec := flag.String("interface", "", "监听的接口 (名称、MAC 地址或序号)，指定后以非交互方式运行")
+	flag.StringVar(ifaceSpec, "i", "", "同 -interface")
+	list := flag.Bool("list", false, "列出活动的接口后退出")
+	flag.BoolVar(&opts.once, "once", false, "获取到第一个认证信息后退出")
+	flag.DurationVar(&opts.timeout, "timeout", 0, "超时时间，例如 30s，0 表示不限制")
+	flag.StringVar(&opts.format, "format", formatText, "认证信息的输出格式 (text, hash, json)")
+	flag.StringVar(&opts.logFormat, "log-format", LogFormatText, "报文日志的格式 (text, json)")
+	logFile := flag.String("log-file", "", "将报文日志追加到文件")
+	flag.StringVar(&opts.hashFile, "hash-file", defaultHashFile, "保存哈希的文件")
+	configFile := flag.String("config", "", "JSON 格式的配置文件")
+	relayUpstream := flag.String("relay-upstream", "", "中继模式: 将 -interface 上的客户端转发到此接口上的 PPPoE 服务器，并记录经过的认证信息")
+	scan := flag.Bool("scan", false, "在 -interface 指定的接口上发送 PADI，列出回应的 PPPoE 服务器后退出")
+	scanWindow := flag.Duration("scan-window", DefaultScanWindow, "扫描时等待 PADO 的时间")
+	service := flag.String("service", "", "-scan、-dial 和 -load 请求的 Service-Name")
+	dial := flag.Bool("dial", false, "作为客户端在 -interface 指定的接口上拨号")
+	username := flag.String("username", "", "-dial 使用的用户名")
+	password := flag.String("password", "", "-dial 使用的密码")
+	load := &loadSettings{}
+	flag.StringVar(&load.subscribers, "load", "", "压力测试: 以此文件中的用户名和密码 (每行一个) 在 -interface 指定的接口上模拟大量客户端拨号")
+	flag.IntVar(&load.count, "load-count", 0, "-load 模拟的客户端数量，0 表示文件中每个用户一个")
+	flag.Float64Var(&load.rate, "load-rate", 0, "-load 每秒发起的拨号数，0 表示不限制")
+	flag.IntVar(&load.concurrency, "load-concurrency", DefaultLoadConcurrency, "-load 同时拨号或在线的客户端数量上限")
+	flag.DurationVar(&load.hold, "load-hold", 0, "-load 每个会话建立后保持的时间")
+	flag.StringVar(&load.baseMAC, "load-mac", "", "-load 第一个客户端的 MAC 地址，其余依次递增，默认随机")
+	flag.Parse()
+	if *capture != "" && *ifaceSpec != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -read cannot be used with -interface")
+		os.Exit(exitUsage)
+	}
+	if *configFile != "" {
+		if err := loadOptions(*configFile, opts); err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(exitError)
+		}
+	}
+	if opts.format != formatText && opts.format != formatHash && opts.format != formatJSON {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown format %s\n", opts.format)
+		os.Exit(exitError)
+	}
+	if opts.logFormat != LogFormatText && opts.logFormat != LogFormatJSON {
+		fmt.Fprintf(os.Stderr, "ERROR: unknown log format %s\n", opts.logFormat)
+		os.Exit(exitError)
+	}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		opts.logOutput = f
+	}
+	if *list {
+		interfaces, err := GetActiveInterfaces()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+			os.Exit(exitInterfaceError)
+		}
+		printInterfaces(interfaces)
+		return
+	}
+	if *scan {
+		if *ifaceSpec == "" {
+			fmt.Fprintln(os.Stderr, "ERROR: -scan requires -interface")
+			os.Exit(exitError)
+		}
+		os.Exit(runScan(*ifaceSpec, opts, *scanWindow, *service))
+	}
+	if *dial {
+		if *ifaceSpec == "" {
+			fmt.Fprintln(os.Stderr, "ERROR: -dial requires -interface")
+			os.Exit(exitError)
+		}
+		os.Exit(runDial(*ifaceSpec, opts, *username, *password, *service))
+	}
+	if load.subscribers != "" {
+		if *ifaceSpec == "" {
+			fmt.Fprintln(os.Stderr, "ERROR: -load requires -interface")
+			os.Exit(exitError)
+		}
+		if load.count < 0 {
+			fmt.Fprintln(os.Stderr, "ERROR: -load-count must not be negative")
+			os.Exit(exitError)
+		}
+		if load.concurrency < 1 {
+			fmt.Fprintln(os.Stderr, "ERROR: -load-concurrency must be at least 1")
+			os.Exit(exitError)
+		}
+		load.service = *service
+		os.Exit(runLoad(*ifaceSpec, opts, load))
+	}
+	if *relayUpstream != "" {
+		if *ifaceSpec == "" {
+			fmt.Fprintln(os.Stderr, "ERROR: -relay-upstream requires -interface")
+			os.Exit(exitError)
+		}
+		os.Exit(runRelay(*ifaceSpec, *relayUpstream, opts))
+	}
+	if *ifaceSpec != "" {
+		os.Exit(runNonInteractive(*ifaceSpec, opts))
+	}
 	fmt.Println("PPPoE 认证模拟器")
+	if *capture != "" {
+		readCapture(*capture, opts.hashFile)
+		return
+	}
 	for {
 		fmt.Println()
 		interfaces, err := GetActiveInterfaces()
@@ -79,6 +266,9 @@ func main() {
 		fmt.Println()
 		fmt.Print("选择一个接口: ")
 		ifIdxStr, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return
+		}
 		if err != nil {
 			continue
 		}
@@ -88,26 +278,18 @@ func main() {
 		}
 		useInterface := interfaces[ifIdx-1]
 		fmt.Printf("正在监听接口: (%s) %s\n", useInterface.HardwareAddr, useInterface.Description)
-		username, password, err := ServePPPoE(useInterface)
-		if err != nil {
-			fmt.Println(err)
+		config := opts.config(useInterface)
+		config.ExitOnCapture = true
+		if config.LogOutput == nil {
+			config.LogOutput = os.Stdout
 		}
-		if username != "" {
-			maxLen := len(username)
-			if len(password) > len(username) {
-				maxLen = len(password)
-			}
-			separator := strings.Repeat("=", maxLen+10)
-			fmt.Println()
-			fmt.Println(separator)
-			fmt.Println()
-			fmt.Println("PPPoE 认证信息")
-			fmt.Println()
-			fmt.Printf("用户名: %s\n", username)
-			fmt.Printf("密码: %s\n", password)
-			fmt.Println()
-			fmt.Println(separator)
+		server := NewServer(config)
+		if err = server.Start(); err != nil {
+			fmt.Println(err)
+		} else {
+			server.Wait()
 		}
+		printCredentials(opts.format, opts.hashFile, server.Credentials())
 		fmt.Println()
 		fmt.Print("按回车键继续...")
 		reader.ReadString('\n')
@@ -0,0 +1,302 @@
+package pppoe
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"math/rand"
+	"net"
+	"strings"
+)
+
+type AuthProtocol struct {
+	Type      layers.PPPType
+	Algorithm byte
+}
+
+var (
+	AuthPAP      = AuthProtocol{PPPTypePasswordAuthentication, 0}
+	AuthCHAPMD5  = AuthProtocol{PPPTypeChallengeAuthentication, ChallengeAlgorithmMD5}
+	AuthMSCHAPv1 = AuthProtocol{PPPTypeChallengeAuthentication, ChallengeAlgorithmMSCHAPv1}
+	AuthMSCHAPv2 = AuthProtocol{PPPTypeChallengeAuthentication, ChallengeAlgorithmMSCHAPv2}
+	AuthEAP      = AuthProtocol{PPPTypeEAP, 0}
+)
+
+// DefaultAuthPreference is the order in which authentication protocols are
+// proposed, weakest first so that clear-text passwords are captured whenever
+// the peer allows it.
+var DefaultAuthPreference = []AuthProtocol{AuthPAP, AuthCHAPMD5, AuthMSCHAPv1, AuthMSCHAPv2, AuthEAP}
+
+var authProtocolNames = map[AuthProtocol]string{
+	AuthPAP:      "PAP",
+	AuthCHAPMD5:  "CHAP-MD5",
+	AuthMSCHAPv1: "MS-CHAPv1",
+	AuthMSCHAPv2: "MS-CHAPv2",
+	AuthEAP:      "EAP",
+}
+
+func (a AuthProtocol) String() string {
+	if name, ok := authProtocolNames[a]; ok {
+		return name
+	}
+	if a.Type == PPPTypeChallengeAuthentication {
+		return fmt.Sprintf("CHAP-0x%02x", a.Algorithm)
+	}
+	return fmt.Sprintf("0x%04x", uint16(a.Type))
+}
+
+// Data returns the value of the LCP Authentication-Protocol option.
+func (a AuthProtocol) Data() []byte {
+	data := UInt16ToBytes(uint16(a.Type))
+	if a.Type == PPPTypeChallengeAuthentication {
+		data = append(data, a.Algorithm)
+	}
+	return data
+}
+
+// ParseAuthName returns the protocol named name as printed by String, e.g.
+// "CHAP-MD5". Case is ignored.
+func ParseAuthName(name string) (AuthProtocol, bool) {
+	for auth, authName := range authProtocolNames {
+		if strings.EqualFold(authName, name) {
+			return auth, true
+		}
+	}
+	return AuthProtocol{}, false
+}
+
+func ParseAuthProtocol(data []byte) (AuthProtocol, bool) {
+	if len(data) < 2 {
+		return AuthProtocol{}, false
+	}
+	auth := AuthProtocol{Type: layers.PPPType(binary.BigEndian.Uint16(data))}
+	if auth.Type == PPPTypeChallengeAuthentication && len(data) > 2 {
+		auth.Algorithm = data[2]
+	}
+	_, ok := authProtocolNames[auth]
+	return auth, ok
+}
+
+// AuthRequest is a credential submitted to an Authenticator, with the
+// session it was received on and the AC-Name of the server.
+type AuthRequest struct {
+	Credential *Credential
+	Peer       net.HardwareAddr
+	SessionID  uint16
+	ACName     string
+}
+
+// AuthResponse is the decision of an Authenticator. Message is sent to the
+// peer with the reply, e.g. in the PAP Authenticate-Nak. FramedAddress, if
+// set, is assigned to an accepted peer instead of an address of the pool.
+type AuthResponse struct {
+	Accept        bool
+	Message       string
+	FramedAddress net.IP
+}
+
+// Authenticator decides whether the peers of a Server are accepted. It is
+// consulted for PAP, CHAP-MD5 and EAP-MD5 credentials, from a goroutine of
+// its own, and ctx is cancelled when the server stops. MS-CHAP exchanges
+// keep failing once captured.
+type Authenticator interface {
+	Authenticate(ctx context.Context, request *AuthRequest) (*AuthResponse, error)
+}
+
+// authSession runs the authentication phase of a session with the protocol
+// agreed on during LCP negotiation.
+type authSession struct {
+	server     *Server
+	peer       net.HardwareAddr
+	sid        uint16
+	protocol   AuthProtocol
+	name       string
+	challenge  []byte
+	identifier byte
+	identity   string
+	// pending is set while an Authenticator decides on the last response.
+	pending       bool
+	succeeded     bool
+	framedAddress net.IP
+}
+
+func newAuthSession(server *Server, peer net.HardwareAddr, sid uint16, protocol AuthProtocol, name string) *authSession {
+	return &authSession{
+		server:     server,
+		peer:       peer,
+		sid:        sid,
+		protocol:   protocol,
+		name:       name,
+		identifier: byte(rand.Intn(256)),
+	}
+}
+
+// start sends the first packet of the authenticator for protocols where the
+// authenticator speaks first.
+func (a *authSession) start() error {
+	switch a.protocol.Type {
+	case PPPTypeChallengeAuthentication:
+		length := 16
+		if a.protocol == AuthMSCHAPv1 {
+			length = 8
+		}
+		challenge, err := secureRandomBytes(length)
+		if err != nil {
+			return err
+		}
+		a.challenge = challenge
+		a.server.sendPPPChallengeAuthentication(a.peer, ChallengeRequest, a.sid, a.identifier, []Option{
+			&PPPChallengeValueOption{ValueSize: byte(len(a.challenge)), Value: a.challenge, Name: []byte(a.name)},
+		})
+		a.server.logOutgoing(a.peer, a.sid, "PPP CHAP", "Challenge")
+	case PPPTypeEAP:
+		a.server.sendPPPEAP(a.peer, EAPRequest, a.sid, a.identifier, EAPTypeIdentity, []Option{})
+		a.server.logOutgoing(a.peer, a.sid, "PPP EAP", "Request Identity")
+	}
+	return nil
+}
+
+func (a *authSession) receivePAP(packet *PPPPasswdAuthentication) *Credential {
+	if packet.Code != AuthenticateRequest || len(packet.Options) == 0 {
+		return nil
+	}
+	if a.pending {
+		return nil
+	}
+	authOption := packet.Options[0].(*PPPPasswdAuthRequestOption)
+	a.identifier = packet.Identifier
+	if a.server.config.Authenticator == nil {
+		a.reply(true, "")
+	}
+	return &Credential{
+		Protocol: PPPTypePasswordAuthentication,
+		Username: string(authOption.PeerId),
+		Password: string(authOption.Passwd),
+	}
+}
+
+func (a *authSession) receiveCHAP(packet *PPPChallengeAuthentication) *Credential {
+	if packet.Code != ChallengeResponse || a.challenge == nil || packet.Identifier != a.identifier || a.pending {
+		return nil
+	}
+	valueOption := packet.Options[0].(*PPPChallengeValueOption)
+	credential := &Credential{
+		Protocol:   PPPTypeChallengeAuthentication,
+		Algorithm:  a.protocol.Algorithm,
+		Username:   string(valueOption.Name),
+		Identifier: packet.Identifier,
+		Challenge:  a.challenge,
+		Response:   valueOption.Value,
+	}
+	if a.protocol == AuthMSCHAPv1 || a.protocol == AuthMSCHAPv2 {
+		// The peer cannot be sent a valid authenticator response without
+		// knowing the password, so fail the exchange once captured.
+		message := "E=691 R=0 V=3"
+		if a.protocol == AuthMSCHAPv2 {
+			// RFC 2759 section 6 requires the challenge in the message.
+			message = fmt.Sprintf("E=691 R=0 C=%X V=3 M=%s", a.challenge, DefaultRejectMessage)
+		}
+		a.server.sendPPPChallengeAuthentication(a.peer, ChallengeFailure, a.sid, packet.Identifier, []Option{
+			&PPPChallengeMessageOption{Message: []byte(message)},
+		})
+		a.server.logOutgoing(a.peer, a.sid, "PPP CHAP", "Failure")
+		return credential
+	}
+	if a.server.config.Authenticator == nil {
+		a.reply(true, "")
+	}
+	return credential
+}
+
+func (a *authSession) receiveEAP(packet *PPPEAP) (*Credential, error) {
+	if packet.Code != EAPResponse || packet.Identifier != a.identifier || a.pending {
+		return nil, nil
+	}
+	switch packet.Type {
+	case EAPTypeIdentity:
+		if len(packet.Options) > 0 {
+			a.identity = string(packet.Options[0].Content())
+		}
+		challenge, err := secureRandomBytes(16)
+		if err != nil {
+			return nil, err
+		}
+		a.identifier++
+		a.challenge = challenge
+		a.server.sendPPPEAP(a.peer, EAPRequest, a.sid, a.identifier, EAPTypeMD5Challenge, []Option{
+			&PPPChallengeValueOption{ValueSize: byte(len(a.challenge)), Value: a.challenge, Name: []byte(a.name)},
+		})
+		a.server.logOutgoing(a.peer, a.sid, "PPP EAP", "Request MD5-Challenge")
+	case EAPTypeMD5Challenge:
+		valueOption := packet.Options[0].(*PPPChallengeValueOption)
+		if a.server.config.Authenticator == nil {
+			a.reply(true, "")
+		}
+		return &Credential{
+			Protocol:   PPPTypeEAP,
+			Algorithm:  byte(EAPTypeMD5Challenge),
+			Username:   a.identity,
+			Identifier: packet.Identifier,
+			Challenge:  a.challenge,
+			Response:   valueOption.Value,
+		}, nil
+	case EAPTypeNak:
+		// The peer does not support EAP-MD5, only its identity is captured.
+		a.server.sendPPPEAP(a.peer, EAPFailure, a.sid, packet.Identifier, 0, []Option{})
+		a.server.logOutgoing(a.peer, a.sid, "PPP EAP", "Failure")
+		return &Credential{Protocol: PPPTypeEAP, Username: a.identity}, nil
+	}
+	return nil, nil
+}
+
+// verifiable reports whether the Authenticator decides on a credential of
+// the session. The others have already been answered.
+func (a *authSession) verifiable(credential *Credential) bool {
+	switch a.protocol {
+	case AuthPAP, AuthCHAPMD5:
+		return true
+	case AuthEAP:
+		return credential.Algorithm == byte(EAPTypeMD5Challenge)
+	}
+	return false
+}
+
+// reply accepts or refuses the last response of the peer. message is sent
+// in the PAP and CHAP replies.
+func (a *authSession) reply(ok bool, message string) {
+	a.succeeded = ok
+	switch a.protocol.Type {
+	case PPPTypePasswordAuthentication:
+		code, name := AuthenticateACK, "Authenticate-Ack"
+		if !ok {
+			code, name = AuthenticationNak, "Authenticate-Nak"
+		}
+		if message != "" {
+			name = fmt.Sprintf("%s: %s", name, message)
+		}
+		a.server.sendPPPPasswdAuthentication(a.peer, code, a.sid, a.identifier, []Option{
+			&PPPPasswdAuthResultOption{MessageLength: byte(len(message)), Message: []byte(message)},
+		})
+		a.server.logOutgoing(a.peer, a.sid, "PPP PAP", name)
+	case PPPTypeChallengeAuthentication:
+		code, name := ChallengeSuccess, "Success"
+		if !ok {
+			code, name = ChallengeFailure, "Failure"
+		}
+		if message != "" {
+			name = fmt.Sprintf("%s: %s", name, message)
+		}
+		a.server.sendPPPChallengeAuthentication(a.peer, code, a.sid, a.identifier, []Option{
+			&PPPChallengeMessageOption{Message: []byte(message)},
+		})
+		a.server.logOutgoing(a.peer, a.sid, "PPP CHAP", name)
+	case PPPTypeEAP:
+		code, name := EAPSuccess, "Success"
+		if !ok {
+			code, name = EAPFailure, "Failure"
+		}
+		a.server.sendPPPEAP(a.peer, code, a.sid, a.identifier, 0, []Option{})
+		a.server.logOutgoing(a.peer, a.sid, "PPP EAP", name)
+	}
+}
@@ -0,0 +1,201 @@
+package pppoe
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"strings"
+)
+
+type PPPChallengeCode byte
+
+const (
+	ChallengeRequest  PPPChallengeCode = 1
+	ChallengeResponse PPPChallengeCode = 2
+	ChallengeSuccess  PPPChallengeCode = 3
+	ChallengeFailure  PPPChallengeCode = 4
+)
+
+func (c PPPChallengeCode) String() string {
+	switch c {
+	case ChallengeRequest:
+		return "Challenge"
+	case ChallengeResponse:
+		return "Response"
+	case ChallengeSuccess:
+		return "Success"
+	case ChallengeFailure:
+		return "Failure"
+	}
+	return fmt.Sprintf("Unknown Code %d", c)
+}
+
+const (
+	ChallengeAlgorithmMD5      byte = 0x05
+	ChallengeAlgorithmMSCHAPv1 byte = 0x80
+	ChallengeAlgorithmMSCHAPv2 byte = 0x81
+)
+
+// MS-CHAP response value layout (RFC 2433 section 3, RFC 2759 section 4).
+// MS-CHAPv2 stores the peer challenge where MS-CHAPv1 has the LM response.
+const (
+	msChapResponseLen        = 49
+	msChapV2PeerChallengeLen = 16
+	msChapNTResponseOffset   = 24
+	msChapNTResponseLen      = 24
+)
+
+// msChapV2ChallengeHash derives the 8-byte challenge that the NT-Response is
+// computed over (RFC 2759 section 8.2).
+func msChapV2ChallengeHash(peerChallenge []byte, authChallenge []byte, username string) []byte {
+	if i := strings.LastIndex(username, "\\"); i >= 0 {
+		username = username[i+1:]
+	}
+	h := sha1.New()
+	h.Write(peerChallenge)
+	h.Write(authChallenge)
+	h.Write([]byte(username))
+	return h.Sum(nil)[:8]
+}
+
+// chapMD5Response computes the response to a CHAP-MD5 or EAP-MD5 challenge
+// (RFC 1994 section 4.1).
+func chapMD5Response(id byte, secret string, challenge []byte) []byte {
+	h := md5.New()
+	h.Write([]byte{id})
+	h.Write([]byte(secret))
+	h.Write(challenge)
+	return h.Sum(nil)
+}
+
+type PPPChallengeValueOption struct {
+	ValueSize byte
+	Value     []byte
+	Name      []byte
+}
+
+func (m *PPPChallengeValueOption) Content() []byte {
+	content := make([]byte, 0)
+	content = append(content, m.ValueSize)
+	content = append(content, m.Value...)
+	content = append(content, m.Name...)
+	return content
+}
+
+func (m *PPPChallengeValueOption) Len() int {
+	return 1 + len(m.Value) + len(m.Name)
+}
+
+func DecodePPPChallengeValueOption(data []byte) []Option {
+	var option PPPChallengeValueOption
+	if len(data) == 0 {
+		return []Option{&option}
+	}
+	option.ValueSize = data[0]
+	i := 1 + int(option.ValueSize)
+	if i > len(data) {
+		i = len(data)
+	}
+	option.Value = data[1:i]
+	option.Name = data[i:]
+	return []Option{&option}
+}
+
+type PPPChallengeMessageOption struct {
+	Message []byte
+}
+
+func (m *PPPChallengeMessageOption) Content() []byte {
+	return m.Message
+}
+
+func (m *PPPChallengeMessageOption) Len() int {
+	return len(m.Message)
+}
+
+func DecodePPPChallengeMessageOption(data []byte) []Option {
+	return []Option{&PPPChallengeMessageOption{Message: data}}
+}
+
+type PPPChallengeAuthentication struct {
+	Code       PPPChallengeCode
+	Identifier byte
+	Length     uint16
+	Options    []Option
+}
+
+var LayerTypePPPChallengeAuthentication = gopacket.RegisterLayerType(
+	2003,
+	gopacket.LayerTypeMetadata{
+		Name:    "LayerTypePPPChallengeAuthentication",
+		Decoder: gopacket.DecodeFunc(nil),
+	},
+)
+
+func (m *PPPChallengeAuthentication) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	bytes, err := b.AppendBytes(4)
+	if err != nil {
+		return err
+	}
+	bytes[0] = byte(m.Code)
+	bytes[1] = m.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], m.Length)
+	for _, op := range m.Options {
+		bytes, err = b.AppendBytes(op.Len())
+		if err != nil {
+			return err
+		}
+		copy(bytes, op.Content())
+	}
+	return nil
+}
+
+func (m *PPPChallengeAuthentication) LayerType() gopacket.LayerType {
+	return LayerTypePPPChallengeAuthentication
+}
+
+func (m *PPPChallengeAuthentication) DecodeFromBytes(data []byte) {
+	m.Code = PPPChallengeCode(data[0])
+	m.Identifier = data[1]
+	m.Length = binary.BigEndian.Uint16(data[2:4])
+	if int(m.Length) > len(data) || m.Length < 4 {
+		m.Length = uint16(len(data))
+	}
+	switch m.Code {
+	case ChallengeRequest, ChallengeResponse:
+		m.Options = DecodePPPChallengeValueOption(data[4:m.Length])
+	case ChallengeSuccess, ChallengeFailure:
+		m.Options = DecodePPPChallengeMessageOption(data[4:m.Length])
+	}
+}
+
+func (s *Server) sendPPPChallengeAuthentication(dst net.HardwareAddr, code PPPChallengeCode, sid uint16, id byte, options []Option) {
+	payload := encodePPPChallengeAuthentication(code, id, options)
+	s.sendPacket(dst, payload, layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(payload)))
+}
+
+func encodePPPChallengeAuthentication(code PPPChallengeCode, id byte, options []Option) []byte {
+	optionsLen := 0
+	for _, op := range options {
+		optionsLen += op.Len()
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	so := gopacket.SerializeOptions{}
+	gopacket.SerializeLayers(buffer, so,
+		&layers.PPP{
+			PPPType: PPPTypeChallengeAuthentication,
+		},
+		&PPPChallengeAuthentication{
+			Code:       code,
+			Identifier: id,
+			Length:     uint16(4 + optionsLen),
+			Options:    options,
+		},
+	)
+	return buffer.Bytes()
+}
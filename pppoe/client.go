@@ -0,0 +1,938 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoOffer              = errors.New("pppoe: no PADO received")
+	ErrNoSession            = errors.New("pppoe: no session confirmed")
+	ErrNegotiationFailed    = errors.New("pppoe: LCP negotiation failed")
+	ErrAuthenticationFailed = errors.New("pppoe: authentication failed")
+	ErrLinkTerminated       = errors.New("pppoe: link terminated by the peer")
+	ErrNoAddress            = errors.New("pppoe: no address assigned")
+)
+
+// clientAuthProtocols are the authentication protocols a Client can answer,
+// the first one being suggested when the concentrator asks for another.
+var clientAuthProtocols = []AuthProtocol{AuthCHAPMD5, AuthPAP, AuthEAP}
+
+// ClientConfig holds the settings of a Client. Zero values are replaced by
+// the package defaults.
+type ClientConfig struct {
+	Interface *Interface
+	// Transport carries the frames of the client. If nil, the interface is
+	// opened with Backend.
+	Transport Transport
+	Backend   string
+	// HardwareAddr is the source address of the client, the address of the
+	// interface if nil.
+	HardwareAddr net.HardwareAddr
+
+	// ServiceName is requested in the PADI, any service if empty. If ACName
+	// is set, offers of other concentrators are ignored.
+	ServiceName string
+	ACName      string
+	Username    string
+	Password    string
+	MRU         uint16
+
+	// RestartTimer and MaxConfigure also bound the retransmissions of the
+	// PADI and PADR.
+	RestartTimer time.Duration
+	MaxConfigure int
+	MaxTerminate int
+	MaxFailure   int
+
+	// LogOutput receives the events of the client, discarded if nil, in the
+	// format selected by LogFormat.
+	LogOutput io.Writer
+	LogFormat string
+}
+
+func (c *ClientConfig) setDefaults() {
+	if c.HardwareAddr == nil && c.Interface != nil {
+		c.HardwareAddr = c.Interface.HardwareAddr
+	}
+	if c.MRU == 0 {
+		c.MRU = DefaultMRU
+	}
+	if c.RestartTimer == 0 {
+		c.RestartTimer = DefaultRestartTimer
+	}
+	if c.MaxConfigure == 0 {
+		c.MaxConfigure = DefaultMaxConfigure
+	}
+	if c.MaxTerminate == 0 {
+		c.MaxTerminate = DefaultMaxTerminate
+	}
+	if c.MaxFailure == 0 {
+		c.MaxFailure = DefaultMaxFailure
+	}
+	if c.LogOutput == nil {
+		c.LogOutput = ioutil.Discard
+	}
+}
+
+// ClientSession describes the session established by a Client.
+type ClientSession struct {
+	AC           net.HardwareAddr
+	ACName       string
+	ID           uint16
+	AuthProtocol AuthProtocol
+	LocalAddress net.IP
+	PeerAddress  net.IP
+	PrimaryDNS   net.IP
+	SecondaryDNS net.IP
+}
+
+// ClientTimings holds how long each phase of Connect took, retransmissions
+// included. Phases that were not completed are zero.
+type ClientTimings struct {
+	// Discovery runs from the first PADI to the accepted PADO, Request from
+	// the first PADR to the PADS.
+	Discovery      time.Duration
+	Request        time.Duration
+	LCP            time.Duration
+	Authentication time.Duration
+	IPCP           time.Duration
+}
+
+// Total returns the time spent in all completed phases.
+func (t ClientTimings) Total() time.Duration {
+	return t.Discovery + t.Request + t.LCP + t.Authentication + t.IPCP
+}
+
+type clientPhase int
+
+const (
+	clientDiscovery clientPhase = iota
+	clientRequest
+	clientLink
+	clientNetwork
+	clientClosed
+)
+
+// Client dials a PPPoE session like a CPE: discovery, LCP, PAP, CHAP-MD5 or
+// EAP-MD5 authentication and IPCP.
+type Client struct {
+	config    ClientConfig
+	mac       net.HardwareAddr
+	transport Transport
+
+	phase    clientPhase
+	attempts int
+	deadline time.Time
+	hostUniq []byte
+	offer    *DiscoveryTags
+	session  ClientSession
+	timings  ClientTimings
+	lapStart time.Time
+
+	lcp        *negotiator
+	lcpOptions *clientLCPHandler
+	// lcpCause is reported when LCP finishes: ErrLinkTerminated once the
+	// peer has sent a Terminate-Request on the opened link,
+	// ErrNegotiationFailed otherwise.
+	lcpCause    error
+	auth        *clientAuth
+	ipcp        *negotiator
+	ipcpOptions *clientIPCPHandler
+
+	result   chan error
+	reported bool
+	err      error
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewClient(config ClientConfig) *Client {
+	config.setDefaults()
+	return &Client{
+		config: config,
+		result: make(chan error, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Dial connects a client with the given configuration. See Client.Connect.
+func Dial(ctx context.Context, config ClientConfig) (*Client, *ClientSession, error) {
+	client := NewClient(config)
+	session, err := client.Connect(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client, session, nil
+}
+
+// Connect opens the interface and establishes a session. It returns once
+// IPCP is opened, or the error that prevented it. The session then stays up
+// until Close is called or the concentrator terminates it.
+func (c *Client) Connect(ctx context.Context) (*ClientSession, error) {
+	if c.config.HardwareAddr == nil {
+		return nil, errors.New("pppoe: no interface configured")
+	}
+	c.mac = c.config.HardwareAddr
+	c.transport = c.config.Transport
+	if c.transport == nil {
+		if c.config.Interface == nil {
+			return nil, errors.New("pppoe: no interface configured")
+		}
+		transport, err := OpenTransport(c.config.Backend, c.config.Interface.Name)
+		if err != nil {
+			return nil, err
+		}
+		c.transport = transport
+	}
+	connected := make(chan ClientSession, 1)
+	go c.run(readFrames(c.transport, c.done), connected)
+	select {
+	case err := <-c.result:
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		session := <-connected
+		return &session, nil
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Timings returns the duration of the phases of Connect. It is valid once
+// Connect has returned.
+func (c *Client) Timings() ClientTimings {
+	return c.timings
+}
+
+// stage names the step Connect had reached, one of LoadPhases. It is valid
+// once Connect has returned.
+func (c *Client) stage() string {
+	switch {
+	case c.ipcp != nil:
+		return "ipcp"
+	case c.auth != nil:
+		return "authentication"
+	case c.lcp != nil:
+		return "lcp"
+	case c.offer != nil:
+		return "request"
+	}
+	return "discovery"
+}
+
+// lap returns the time elapsed since the previous phase ended.
+func (c *Client) lap() time.Duration {
+	now := time.Now()
+	d := now.Sub(c.lapStart)
+	c.lapStart = now
+	return d
+}
+
+// Close terminates the session and waits for the client to shut down.
+func (c *Client) Close() error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+	return nil
+}
+
+// Wait blocks until the session has ended and returns the reason, nil if
+// it was closed by Close.
+func (c *Client) Wait() error {
+	<-c.done
+	return c.err
+}
+
+func (c *Client) run(packets chan gopacket.Packet, connected chan ClientSession) {
+	defer close(c.done)
+	defer c.transport.Close()
+	c.hostUniq = GenerateRandomBytes(8)
+	c.lapStart = time.Now()
+	c.sendPADI()
+	ticker := time.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+	for c.phase != clientClosed {
+		select {
+		case <-c.stop:
+			c.terminate()
+			c.finish(nil)
+		case now := <-ticker.C:
+			c.tick(now)
+		case packet, ok := <-packets:
+			if !ok {
+				c.finish(ErrTransportClosed)
+				break
+			}
+			c.handlePacket(packet)
+		}
+		if c.phase == clientNetwork && !c.reported {
+			connected <- c.session
+			c.result <- nil
+			c.reported = true
+		}
+	}
+}
+
+// finish ends the client, reporting err to Connect if it is still waiting.
+func (c *Client) finish(err error) {
+	if c.phase == clientClosed {
+		return
+	}
+	if !c.reported {
+		if err == nil {
+			err = context.Canceled
+		}
+		c.result <- err
+		c.reported = true
+	}
+	c.err = err
+	c.phase = clientClosed
+}
+
+func (c *Client) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Local == nil {
+		e.Local = c.mac
+	}
+	if e.Peer == nil {
+		e.Peer = c.session.AC
+	}
+	if e.SessionID == 0 {
+		e.SessionID = c.session.ID
+	}
+	if e.Type == "" {
+		e.Type = eventTypes[e.Protocol]
+	}
+	writeEvent(c.config.LogOutput, c.config.LogFormat, &e)
+}
+
+func (c *Client) logIncoming(protocol string, message interface{}) {
+	c.emit(Event{Direction: EventIncoming, Protocol: protocol, Message: fmt.Sprint(message)})
+}
+
+func (c *Client) logOutgoing(protocol string, message interface{}) {
+	c.emit(Event{Direction: EventOutgoing, Protocol: protocol, Message: fmt.Sprint(message)})
+}
+
+func (c *Client) send(dst net.HardwareAddr, payload []byte, code layers.PPPoECode, sid uint16, protocol layers.EthernetType) {
+	c.transport.WriteFrame(buildFrame(c.mac, dst, payload, code, sid, protocol, uint16(len(payload))))
+}
+
+func (c *Client) sendPPP(payload []byte) {
+	c.send(c.session.AC, payload, layers.PPPoECodeSession, c.session.ID, layers.EthernetTypePPPoESession)
+}
+
+func (c *Client) sendPADI() {
+	payload := PPPoETags([]PPPoETag{{TagNameServiceName, c.config.ServiceName}, {TagNameHostUniq, c.hostUniq}})
+	c.send(broadcastMAC, payload, layers.PPPoECodePADI, 0, layers.EthernetTypePPPoEDiscovery)
+	c.deadline = time.Now().Add(c.config.RestartTimer)
+	c.emit(Event{Direction: EventOutgoing, Peer: broadcastMAC, Protocol: "PPPoED", Message: "Active Discovery Initiation (PADI)"})
+}
+
+func (c *Client) sendPADR() {
+	tags := []PPPoETag{{TagNameServiceName, c.config.ServiceName}, {TagNameHostUniq, c.hostUniq}}
+	if c.offer.ACCookie != nil {
+		tags = append(tags, PPPoETag{TagNameACCookie, c.offer.ACCookie})
+	}
+	if c.offer.RelaySessionID != nil {
+		tags = append(tags, PPPoETag{TagNameRelaySessionID, c.offer.RelaySessionID})
+	}
+	payload := PPPoETags(tags)
+	c.send(c.session.AC, payload, layers.PPPoECodePADR, 0, layers.EthernetTypePPPoEDiscovery)
+	c.deadline = time.Now().Add(c.config.RestartTimer)
+	c.logOutgoing("PPPoED", "Active Discovery Request (PADR)")
+}
+
+func (c *Client) sendPADT() {
+	c.send(c.session.AC, make([]byte, 0), layers.PPPoECodePADT, c.session.ID, layers.EthernetTypePPPoEDiscovery)
+	c.emit(Event{Type: EventTermination, Direction: EventOutgoing, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+}
+
+// terminate closes the link from our side.
+func (c *Client) terminate() {
+	if c.phase == clientLink || c.phase == clientNetwork {
+		c.lcp.Close()
+		c.sendPADT()
+	}
+}
+
+func (c *Client) tick(now time.Time) {
+	switch c.phase {
+	case clientDiscovery, clientRequest:
+		if now.Before(c.deadline) {
+			return
+		}
+		c.attempts++
+		if c.attempts >= c.config.MaxConfigure {
+			if c.phase == clientDiscovery {
+				c.finish(ErrNoOffer)
+			} else {
+				c.finish(ErrNoSession)
+			}
+			return
+		}
+		if c.phase == clientDiscovery {
+			c.sendPADI()
+		} else {
+			c.sendPADR()
+		}
+	case clientLink, clientNetwork:
+		c.lcp.Tick(now)
+		if c.auth != nil {
+			c.auth.tick(now)
+		}
+		if c.ipcp != nil {
+			c.ipcp.Tick(now)
+		}
+	}
+}
+
+func (c *Client) handlePacket(packet gopacket.Packet) {
+	ethernet, pppoe := pppoeLayers(packet, c.mac, false)
+	if pppoe == nil {
+		return
+	}
+	switch pppoe.Code {
+	case layers.PPPoECodePADO:
+		if c.phase != clientDiscovery {
+			return
+		}
+		tags := DecodeDiscoveryTags(pppoe.Payload)
+		c.emit(Event{Direction: EventIncoming, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: "Active Discovery Offer (PADO)", Fields: tags.fields()})
+		if !bytes.Equal(tags.HostUniq, c.hostUniq) || (c.config.ACName != "" && tags.ACName != c.config.ACName) {
+			return
+		}
+		c.offer = tags
+		c.session.AC = ethernet.SrcMAC
+		c.session.ACName = tags.ACName
+		c.timings.Discovery = c.lap()
+		c.phase = clientRequest
+		c.attempts = 0
+		c.sendPADR()
+	case layers.PPPoECodePADS:
+		if c.phase != clientRequest || !bytes.Equal(ethernet.SrcMAC, c.session.AC) {
+			return
+		}
+		tags := DecodeDiscoveryTags(pppoe.Payload)
+		if !bytes.Equal(tags.HostUniq, c.hostUniq) {
+			return
+		}
+		if name, message, ok := tags.Error(); ok || pppoe.SessionId == 0 {
+			c.emit(Event{Type: EventError, Direction: EventIncoming, Protocol: "PPPoED", Message: fmt.Sprintf("Active Discovery Session-confirmation (PADS), %s: %s", name, message)})
+			c.finish(ErrNoSession)
+			return
+		}
+		c.session.ID = pppoe.SessionId
+		c.timings.Request = c.lap()
+		c.logIncoming("PPPoED", fmt.Sprintf("Active Discovery Session-confirmation (PADS), session %d", pppoe.SessionId))
+		c.startLCP()
+	case layers.PPPoECodePADT:
+		if c.phase < clientLink || pppoe.SessionId != c.session.ID || !bytes.Equal(ethernet.SrcMAC, c.session.AC) {
+			return
+		}
+		c.emit(Event{Type: EventTermination, Direction: EventIncoming, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+		c.lcp.Down()
+		c.finish(ErrLinkTerminated)
+	case layers.PPPoECodeSession:
+		if c.phase < clientLink || pppoe.SessionId != c.session.ID || !bytes.Equal(ethernet.SrcMAC, c.session.AC) {
+			return
+		}
+		if pppLayer := packet.Layer(layers.LayerTypePPP); pppLayer != nil {
+			c.handlePPP(pppLayer.(*layers.PPP))
+		}
+	}
+}
+
+// newControlNegotiator returns an automaton for a control protocol of the
+// session using the timers of the configuration.
+func (c *Client) newControlNegotiator(handler negotiationHandler, protocol layers.PPPType, name string) *negotiator {
+	n := newNegotiator(handler, func(code PPPLCPCode, id byte, options []Option) {
+		c.sendPPP(encodeControlProtocol(protocol, code, id, options))
+		c.logOutgoing(name, code)
+	})
+	n.RestartTimer = c.config.RestartTimer
+	n.MaxConfigure = c.config.MaxConfigure
+	n.MaxTerminate = c.config.MaxTerminate
+	n.MaxFailure = c.config.MaxFailure
+	return n
+}
+
+func (c *Client) startLCP() {
+	c.phase = clientLink
+	c.lcpOptions = newClientLCPHandler(c.config.MRU)
+	c.lcpOptions.onUp = func() {
+		c.timings.LCP = c.lap()
+		c.session.AuthProtocol = c.lcpOptions.authProtocol
+		if c.session.AuthProtocol.Type == 0 {
+			c.startIPCP()
+			return
+		}
+		c.auth = newClientAuth(c, c.session.AuthProtocol)
+		c.auth.start()
+	}
+	c.lcpCause = ErrNegotiationFailed
+	c.lcpOptions.onFinished = func() {
+		if c.phase != clientClosed {
+			c.sendPADT()
+			c.finish(c.lcpCause)
+		}
+	}
+	c.lcp = c.newControlNegotiator(c.lcpOptions, PPPTypeLCP, "PPP LCP")
+	c.lcp.Up()
+	c.lcp.Open()
+}
+
+func (c *Client) startIPCP() {
+	c.ipcpOptions = newClientIPCPHandler()
+	c.ipcpOptions.onUp = func() {
+		c.session.LocalAddress = c.ipcpOptions.address(PPPIPCPOptionTypeIPAddress)
+		c.session.PrimaryDNS = c.ipcpOptions.address(PPPIPCPOptionTypePrimaryDNS)
+		c.session.SecondaryDNS = c.ipcpOptions.address(PPPIPCPOptionTypeSecondaryDNS)
+		c.session.PeerAddress = c.ipcpOptions.peerAddress
+		c.timings.IPCP = c.lap()
+		c.phase = clientNetwork
+		c.emit(Event{
+			Direction: EventIncoming,
+			Protocol:  "PPP IPCP",
+			Message:   fmt.Sprintf("Opened, local address %s, peer address %s", c.session.LocalAddress, c.session.PeerAddress),
+			Fields:    map[string]interface{}{"local_address": c.session.LocalAddress.String(), "peer_address": c.session.PeerAddress.String()},
+		})
+	}
+	// IPCP finishing before it is opened means no address was assigned.
+	c.ipcpOptions.onFinished = func() {
+		if c.phase == clientLink {
+			c.terminate()
+			c.finish(ErrNoAddress)
+		}
+	}
+	c.ipcp = c.newControlNegotiator(c.ipcpOptions, PPPTypeIPCP, "PPP IPCP")
+	c.ipcp.Up()
+	c.ipcp.Open()
+}
+
+func (c *Client) handlePPP(ppp *layers.PPP) {
+	if len(ppp.Payload) < 4 {
+		return
+	}
+	switch ppp.PPPType {
+	case PPPTypeLCP:
+		var lcpLayer PPPLCP
+		lcpLayer.DecodeFromBytes(ppp.Payload)
+		c.logIncoming("PPP LCP", lcpLayer.Code)
+		switch lcpLayer.Code {
+		case PPPLCPCodeEchoRequest:
+			if c.lcp.State() == PPPStateOpened {
+				data := lcpLayer.Options[0].(*PPPLCPEchoOption).Data
+				c.sendPPP(encodeControlProtocol(PPPTypeLCP, PPPLCPCodeEchoReply, lcpLayer.Identifier, []Option{
+					&PPPLCPEchoOption{Magic: c.lcpOptions.magic, Data: data},
+				}))
+				c.logOutgoing("PPP LCP", PPPLCPCodeEchoReply)
+			}
+		case PPPLCPCodeEchoReply, PPPLCPCodeDiscardRequest, PPPLCPCodeProtocolReject:
+		default:
+			if lcpLayer.Code == PPPLCPCodeTerminateRequest && c.lcp.State() == PPPStateOpened {
+				c.lcpCause = ErrLinkTerminated
+			}
+			c.lcp.Input(lcpLayer.Code, lcpLayer.Identifier, lcpLayer.Options, ppp.Payload[:lcpLayer.Length])
+		}
+	case PPPTypePasswordAuthentication:
+		if c.auth == nil || c.auth.protocol != AuthPAP {
+			return
+		}
+		var passwdLayer PPPPasswdAuthentication
+		passwdLayer.DecodeFromBytes(ppp.Payload)
+		c.logIncoming("PPP PAP", passwdLayer.Code)
+		c.auth.receivePAP(&passwdLayer)
+	case PPPTypeChallengeAuthentication:
+		if c.auth == nil || c.auth.protocol.Type != PPPTypeChallengeAuthentication {
+			return
+		}
+		var chapLayer PPPChallengeAuthentication
+		chapLayer.DecodeFromBytes(ppp.Payload)
+		c.logIncoming("PPP CHAP", chapLayer.Code)
+		c.auth.receiveCHAP(&chapLayer)
+	case PPPTypeEAP:
+		if c.auth == nil || c.auth.protocol != AuthEAP {
+			return
+		}
+		var eapLayer PPPEAP
+		eapLayer.DecodeFromBytes(ppp.Payload)
+		c.logIncoming("PPP EAP", eapLayer.Code)
+		c.auth.receiveEAP(&eapLayer)
+	case PPPTypeIPCP:
+		if c.ipcp == nil {
+			return
+		}
+		var ipcpLayer PPPIPCP
+		ipcpLayer.DecodeFromBytes(ppp.Payload)
+		c.logIncoming("PPP IPCP", ipcpLayer.Code)
+		c.ipcp.Input(ipcpLayer.Code, ipcpLayer.Identifier, ipcpLayer.Options, ppp.Payload[:ipcpLayer.Length])
+	default:
+		if c.lcp.State() != PPPStateOpened {
+			return
+		}
+		c.sendPPP(encodeControlProtocol(PPPTypeLCP, PPPLCPCodeProtocolReject, c.lcp.nextRejectID(), []Option{
+			&PPPLCPRejectOption{Data: append(UInt16ToBytes(uint16(ppp.PPPType)), ppp.Payload...)},
+		}))
+		c.logOutgoing("PPP LCP", PPPLCPCodeProtocolReject)
+	}
+}
+
+// authenticated enters the network phase, or ends the client if the
+// concentrator refused the credentials.
+func (c *Client) authenticated(ok bool) {
+	if !ok {
+		c.emit(Event{Type: EventError, Direction: EventIncoming, Protocol: "PPP LCP", Message: fmt.Sprintf("%s authentication of %s failed", c.session.AuthProtocol, c.config.Username)})
+		c.terminate()
+		c.finish(ErrAuthenticationFailed)
+		return
+	}
+	c.auth = nil
+	c.timings.Authentication = c.lap()
+	c.startIPCP()
+}
+
+// clientLCPHandler negotiates the link options of the client side.
+type clientLCPHandler struct {
+	mru          uint16
+	magic        uint32
+	authProtocol AuthProtocol
+	rejected     map[PPPLCPOptionType]bool
+
+	onUp       func()
+	onFinished func()
+}
+
+func newClientLCPHandler(mru uint16) *clientLCPHandler {
+	return &clientLCPHandler{
+		mru:      mru,
+		magic:    binary.BigEndian.Uint32(GenerateRandomBytes(4)),
+		rejected: make(map[PPPLCPOptionType]bool),
+	}
+}
+
+func (h *clientLCPHandler) requestOptions() []Option {
+	options := make([]Option, 0)
+	if !h.rejected[PPPLCPOptionTypeMRU] {
+		options = append(options, &PPPLCPOption{Type: PPPLCPOptionTypeMRU, Length: 4, Data: UInt16ToBytes(h.mru)})
+	}
+	if !h.rejected[PPPLCPOptionTypeMagicNumber] {
+		options = append(options, &PPPLCPOption{Type: PPPLCPOptionTypeMagicNumber, Length: 6, Data: UInt32ToBytes(h.magic)})
+	}
+	return options
+}
+
+func (h *clientLCPHandler) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	naks := make([]Option, 0)
+	rejects := make([]Option, 0)
+	for _, op := range options {
+		lcpOp, ok := op.(*PPPLCPOption)
+		if !ok {
+			continue
+		}
+		switch lcpOp.Type {
+		case PPPLCPOptionTypeMRU:
+			if len(lcpOp.Data) != 2 {
+				rejects = append(rejects, op)
+			} else if binary.BigEndian.Uint16(lcpOp.Data) < MinMRU {
+				naks = append(naks, &PPPLCPOption{Type: PPPLCPOptionTypeMRU, Length: 4, Data: UInt16ToBytes(MinMRU)})
+			}
+		case PPPLCPOptionTypeMagicNumber:
+			if len(lcpOp.Data) != 4 {
+				rejects = append(rejects, op)
+			} else if magic := binary.BigEndian.Uint32(lcpOp.Data); magic == 0 || magic == h.magic {
+				naks = append(naks, &PPPLCPOption{Type: PPPLCPOptionTypeMagicNumber, Length: 6, Data: GenerateRandomBytes(4)})
+			}
+		case PPPLCPOptionTypeAuthenticationProtocol:
+			if !h.supports(lcpOp.Data) {
+				suggested := clientAuthProtocols[0].Data()
+				naks = append(naks, &PPPLCPOption{Type: PPPLCPOptionTypeAuthenticationProtocol, Length: byte(2 + len(suggested)), Data: suggested})
+			}
+		default:
+			rejects = append(rejects, op)
+		}
+	}
+	if len(rejects) > 0 {
+		return PPPLCPCodeConfigurationReject, rejects
+	}
+	if len(naks) > 0 {
+		return PPPLCPCodeConfigurationNak, naks
+	}
+	h.authProtocol = AuthProtocol{}
+	if authOption := FindLCPOption(options, PPPLCPOptionTypeAuthenticationProtocol); authOption != nil {
+		h.authProtocol, _ = ParseAuthProtocol(authOption.Data)
+	}
+	return PPPLCPCodeConfigurationAck, options
+}
+
+func (h *clientLCPHandler) supports(data []byte) bool {
+	auth, ok := ParseAuthProtocol(data)
+	if !ok {
+		return false
+	}
+	for _, supported := range clientAuthProtocols {
+		if auth == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *clientLCPHandler) nakReceived(options []Option) bool {
+	if mruOption := FindLCPOption(options, PPPLCPOptionTypeMRU); mruOption != nil && len(mruOption.Data) == 2 {
+		if mru := binary.BigEndian.Uint16(mruOption.Data); mru >= MinMRU {
+			h.mru = mru
+		}
+	}
+	if FindLCPOption(options, PPPLCPOptionTypeMagicNumber) != nil {
+		h.magic = binary.BigEndian.Uint32(GenerateRandomBytes(4))
+	}
+	return true
+}
+
+func (h *clientLCPHandler) rejectReceived(options []Option) bool {
+	for _, op := range options {
+		if lcpOp, ok := op.(*PPPLCPOption); ok {
+			h.rejected[lcpOp.Type] = true
+		}
+	}
+	return true
+}
+
+func (h *clientLCPHandler) layerUp() {
+	if h.onUp != nil {
+		h.onUp()
+	}
+}
+
+func (h *clientLCPHandler) layerDown() {
+}
+
+func (h *clientLCPHandler) layerFinished() {
+	if h.onFinished != nil {
+		h.onFinished()
+	}
+}
+
+// clientAuth runs the authentication phase of the client, the peer side of
+// authSession.
+type clientAuth struct {
+	client     *Client
+	protocol   AuthProtocol
+	identifier byte
+	attempts   int
+	deadline   time.Time
+}
+
+func newClientAuth(client *Client, protocol AuthProtocol) *clientAuth {
+	return &clientAuth{client: client, protocol: protocol, identifier: GenerateRandomBytes(1)[0]}
+}
+
+// start sends the Authenticate-Request of PAP, where the peer speaks first.
+func (a *clientAuth) start() {
+	if a.protocol != AuthPAP {
+		return
+	}
+	a.identifier++
+	a.attempts++
+	a.deadline = time.Now().Add(a.client.config.RestartTimer)
+	config := a.client.config
+	a.client.sendPPP(encodePPPPasswdAuthentication(AuthenticateRequest, a.identifier, []Option{
+		&PPPPasswdAuthRequestOption{
+			PeerIdLength: byte(len(config.Username)),
+			PeerId:       []byte(config.Username),
+			PasswdLength: byte(len(config.Password)),
+			Passwd:       []byte(config.Password),
+		},
+	}))
+	a.client.logOutgoing("PPP PAP", AuthenticateRequest)
+}
+
+// tick retransmits the PAP Authenticate-Request until it is answered.
+func (a *clientAuth) tick(now time.Time) {
+	if a.deadline.IsZero() || now.Before(a.deadline) {
+		return
+	}
+	if a.attempts >= a.client.config.MaxConfigure {
+		a.deadline = time.Time{}
+		a.client.authenticated(false)
+		return
+	}
+	a.start()
+}
+
+func (a *clientAuth) receivePAP(packet *PPPPasswdAuthentication) {
+	if packet.Identifier != a.identifier || (packet.Code != AuthenticateACK && packet.Code != AuthenticationNak) {
+		return
+	}
+	a.deadline = time.Time{}
+	a.client.authenticated(packet.Code == AuthenticateACK)
+}
+
+func (a *clientAuth) receiveCHAP(packet *PPPChallengeAuthentication) {
+	switch packet.Code {
+	case ChallengeRequest:
+		challenge := packet.Options[0].(*PPPChallengeValueOption).Value
+		response := chapMD5Response(packet.Identifier, a.client.config.Password, challenge)
+		a.identifier = packet.Identifier
+		a.client.sendPPP(encodePPPChallengeAuthentication(ChallengeResponse, packet.Identifier, []Option{
+			&PPPChallengeValueOption{ValueSize: byte(len(response)), Value: response, Name: []byte(a.client.config.Username)},
+		}))
+		a.client.logOutgoing("PPP CHAP", ChallengeResponse)
+	case ChallengeSuccess, ChallengeFailure:
+		if packet.Identifier == a.identifier {
+			a.client.authenticated(packet.Code == ChallengeSuccess)
+		}
+	}
+}
+
+func (a *clientAuth) receiveEAP(packet *PPPEAP) {
+	switch packet.Code {
+	case EAPRequest:
+		switch packet.Type {
+		case EAPTypeIdentity:
+			a.client.sendPPP(encodePPPEAP(EAPResponse, packet.Identifier, EAPTypeIdentity, []Option{
+				&PPPEAPTypeDataOption{Data: []byte(a.client.config.Username)},
+			}))
+			a.client.logOutgoing("PPP EAP", "Response Identity")
+		case EAPTypeMD5Challenge:
+			challenge := packet.Options[0].(*PPPChallengeValueOption).Value
+			response := chapMD5Response(packet.Identifier, a.client.config.Password, challenge)
+			a.client.sendPPP(encodePPPEAP(EAPResponse, packet.Identifier, EAPTypeMD5Challenge, []Option{
+				&PPPChallengeValueOption{ValueSize: byte(len(response)), Value: response, Name: []byte(a.client.config.Username)},
+			}))
+			a.client.logOutgoing("PPP EAP", "Response MD5-Challenge")
+		case EAPTypeNotification:
+			a.client.sendPPP(encodePPPEAP(EAPResponse, packet.Identifier, EAPTypeNotification, []Option{}))
+		default:
+			// Only EAP-MD5 is implemented, propose it instead.
+			a.client.sendPPP(encodePPPEAP(EAPResponse, packet.Identifier, EAPTypeNak, []Option{
+				&PPPEAPTypeDataOption{Data: []byte{byte(EAPTypeMD5Challenge)}},
+			}))
+			a.client.logOutgoing("PPP EAP", "Response Nak")
+		}
+	case EAPSuccess, EAPFailure:
+		a.client.authenticated(packet.Code == EAPSuccess)
+	}
+}
+
+// clientIPCPHandler requests an address and name servers from the
+// concentrator.
+type clientIPCPHandler struct {
+	requested   map[PPPIPCPOptionType]net.IP
+	peerAddress net.IP
+	rejected    map[PPPIPCPOptionType]bool
+
+	onUp       func()
+	onFinished func()
+}
+
+// clientIPCPOptions are requested in this order, starting at 0.0.0.0 so
+// that the concentrator assigns them with a Configure-Nak.
+var clientIPCPOptions = []PPPIPCPOptionType{PPPIPCPOptionTypeIPAddress, PPPIPCPOptionTypePrimaryDNS, PPPIPCPOptionTypeSecondaryDNS}
+
+func newClientIPCPHandler() *clientIPCPHandler {
+	h := &clientIPCPHandler{
+		requested: make(map[PPPIPCPOptionType]net.IP),
+		rejected:  make(map[PPPIPCPOptionType]bool),
+	}
+	for _, optionType := range clientIPCPOptions {
+		h.requested[optionType] = net.IPv4zero
+	}
+	return h
+}
+
+// address returns the negotiated value of an option, nil if it was
+// rejected.
+func (h *clientIPCPHandler) address(optionType PPPIPCPOptionType) net.IP {
+	if h.rejected[optionType] {
+		return nil
+	}
+	return h.requested[optionType]
+}
+
+func (h *clientIPCPHandler) requestOptions() []Option {
+	options := make([]Option, 0)
+	for _, optionType := range clientIPCPOptions {
+		if !h.rejected[optionType] {
+			options = append(options, newIPCPAddressOption(optionType, h.requested[optionType]))
+		}
+	}
+	return options
+}
+
+func (h *clientIPCPHandler) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	rejects := make([]Option, 0)
+	for _, op := range options {
+		ipcpOp, ok := op.(*PPPIPCPOption)
+		if !ok {
+			continue
+		}
+		if ipcpOp.Type != PPPIPCPOptionTypeIPAddress || len(ipcpOp.Data) != 4 {
+			rejects = append(rejects, op)
+		}
+	}
+	if len(rejects) > 0 {
+		return PPPLCPCodeConfigurationReject, rejects
+	}
+	if addressOption := FindIPCPOption(options, PPPIPCPOptionTypeIPAddress); addressOption != nil {
+		h.peerAddress = net.IP(append([]byte(nil), addressOption.Data...))
+	}
+	return PPPLCPCodeConfigurationAck, options
+}
+
+func (h *clientIPCPHandler) nakReceived(options []Option) bool {
+	for _, op := range options {
+		if ipcpOp, ok := op.(*PPPIPCPOption); ok && len(ipcpOp.Data) == 4 {
+			if _, requested := h.requested[ipcpOp.Type]; requested {
+				h.requested[ipcpOp.Type] = net.IP(append([]byte(nil), ipcpOp.Data...))
+			}
+		}
+	}
+	return true
+}
+
+func (h *clientIPCPHandler) rejectReceived(options []Option) bool {
+	for _, op := range options {
+		if ipcpOp, ok := op.(*PPPIPCPOption); ok {
+			if ipcpOp.Type == PPPIPCPOptionTypeIPAddress {
+				return false
+			}
+			h.rejected[ipcpOp.Type] = true
+		}
+	}
+	return true
+}
+
+func (h *clientIPCPHandler) layerUp() {
+	if h.onUp != nil {
+		h.onUp()
+	}
+}
+
+func (h *clientIPCPHandler) layerDown() {
+}
+
+func (h *clientIPCPHandler) layerFinished() {
+	if h.onFinished != nil {
+		h.onFinished()
+	}
+}
@@ -0,0 +1,107 @@
+package pppoe
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/google/gopacket/layers"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// filterTransport drops the frames written to it that match drop.
+type filterTransport struct {
+	Transport
+	drop func(frame []byte) bool
+}
+
+func (t filterTransport) WriteFrame(frame []byte) error {
+	if t.drop != nil && t.drop(frame) {
+		return nil
+	}
+	return t.Transport.WriteFrame(frame)
+}
+
+func isPADT(frame []byte) bool {
+	return len(frame) > 15 && layers.EthernetType(binary.BigEndian.Uint16(frame[12:14])) == layers.EthernetTypePPPoEDiscovery &&
+		layers.PPPoECode(frame[15]) == layers.PPPoECodePADT
+}
+
+func isSessionFrame(frame []byte) bool {
+	return len(frame) > 14 && layers.EthernetType(binary.BigEndian.Uint16(frame[12:14])) == layers.EthernetTypePPPoESession
+}
+
+// TestClientFinishCause checks the error a Client reports for each way its
+// session can end.
+func TestClientFinishCause(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator Authenticator
+		drop          func(frame []byte) bool
+		// wantConnect is the error of Connect, wantWait the one of Wait
+		// once connected.
+		wantConnect error
+		wantWait    error
+	}{
+		{
+			name:     "PADT",
+			wantWait: ErrLinkTerminated,
+		},
+		{
+			name:     "Terminate-Request",
+			drop:     isPADT,
+			wantWait: ErrLinkTerminated,
+		},
+		{
+			name:          "authentication failed",
+			authenticator: NewUserDatabase(nil),
+			wantConnect:   ErrAuthenticationFailed,
+		},
+		{
+			name:        "negotiation failed",
+			drop:        isSessionFrame,
+			wantConnect: ErrNegotiationFailed,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverEnd, clientEnd := NewPipe()
+			server := NewServer(Config{
+				Interface:       &Interface{Name: "pipe0", HardwareAddr: testServerMAC},
+				Transport:       filterTransport{Transport: serverEnd, drop: test.drop},
+				AuthPreference:  []AuthProtocol{AuthPAP},
+				Authenticator:   test.authenticator,
+				SessionHoldTime: 100 * time.Millisecond,
+				RestartTimer:    50 * time.Millisecond,
+				LogOutput:       ioutil.Discard,
+			})
+			if err := server.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer server.Stop()
+			client := NewClient(ClientConfig{
+				Transport:    clientEnd,
+				HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 2},
+				Username:     "user",
+				Password:     "secret",
+				RestartTimer: 50 * time.Millisecond,
+				MaxConfigure: 2,
+				LogOutput:    ioutil.Discard,
+			})
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := client.Connect(ctx)
+			if err != test.wantConnect {
+				t.Fatalf("Connect = %v, want %v", err, test.wantConnect)
+			}
+			if err != nil {
+				return
+			}
+			if err := client.Wait(); err != test.wantWait {
+				t.Errorf("Wait = %v, want %v", err, test.wantWait)
+			}
+		})
+	}
+}
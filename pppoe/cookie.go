@@ -0,0 +1,91 @@
+package pppoe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+	"time"
+)
+
+// DefaultCookieLifetime is how long a secret signs new AC-Cookies. Cookies
+// signed with the previous secret are still accepted, so a cookie stays
+// valid for at least this long.
+const DefaultCookieLifetime = 5 * time.Minute
+
+// MaxCookieLength is the size of the HMAC an AC-Cookie is truncated from.
+const MaxCookieLength = sha256.Size
+
+// cookieJar issues and verifies stateless AC-Cookies: the HMAC-SHA256 of the
+// client MAC address under a secret that is replaced every lifetime. A PADR
+// can then be checked against the PADO without keeping any state per PADI.
+type cookieJar struct {
+	length   int
+	lifetime time.Duration
+	current  []byte
+	previous []byte
+	rotated  time.Time
+}
+
+// newCookieJar returns a jar issuing cookies of length bytes, the default
+// length if it is not positive and at most the size of the HMAC.
+func newCookieJar(length int, lifetime time.Duration) (*cookieJar, error) {
+	if length <= 0 {
+		length = defaultCookieLength
+	}
+	if length > MaxCookieLength {
+		length = MaxCookieLength
+	}
+	secret, err := secureRandomBytes(sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &cookieJar{
+		length:   length,
+		lifetime: lifetime,
+		current:  secret,
+		rotated:  time.Now(),
+	}, nil
+}
+
+func (j *cookieJar) rotate(now time.Time) error {
+	if now.Sub(j.rotated) < j.lifetime {
+		return nil
+	}
+	secret, err := secureRandomBytes(sha256.Size)
+	if err != nil {
+		return err
+	}
+	j.previous = j.current
+	if now.Sub(j.rotated) >= 2*j.lifetime {
+		// The previous secret has expired as well.
+		j.previous = nil
+	}
+	j.current = secret
+	j.rotated = now
+	return nil
+}
+
+func (j *cookieJar) sign(secret []byte, mac net.HardwareAddr) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write(mac)
+	return h.Sum(nil)[:j.length]
+}
+
+// issue returns the cookie to send to mac in a PADO.
+func (j *cookieJar) issue(mac net.HardwareAddr) ([]byte, error) {
+	if err := j.rotate(time.Now()); err != nil {
+		return nil, err
+	}
+	return j.sign(j.current, mac), nil
+}
+
+// verify tells whether cookie was issued to mac by a current secret.
+func (j *cookieJar) verify(mac net.HardwareAddr, cookie []byte) (bool, error) {
+	if err := j.rotate(time.Now()); err != nil {
+		return false, err
+	}
+	if hmac.Equal(cookie, j.sign(j.current, mac)) {
+		return true, nil
+	}
+	return j.previous != nil && hmac.Equal(cookie, j.sign(j.previous, mac)), nil
+}
@@ -0,0 +1,110 @@
+package pppoe
+
+import (
+	"crypto/sha256"
+	"net"
+	"testing"
+	"time"
+)
+
+func mustNewCookieJar(t *testing.T, length int) *cookieJar {
+	j, err := newCookieJar(length, time.Minute)
+	if err != nil {
+		t.Fatalf("newCookieJar: %v", err)
+	}
+	return j
+}
+
+func TestCookieJarLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		want   int
+	}{
+		{"short", 8, 8},
+		{"zero", 0, defaultCookieLength},
+		{"negative", -1, defaultCookieLength},
+		{"full HMAC", sha256.Size, sha256.Size},
+		{"longer than the HMAC", 64, sha256.Size},
+	}
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cookie, err := mustNewCookieJar(t, test.length).issue(mac)
+			if err != nil {
+				t.Fatalf("issue: %v", err)
+			}
+			if got := len(cookie); got != test.want {
+				t.Errorf("cookie length = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCookieJarVerify(t *testing.T) {
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	other := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	tests := []struct {
+		name string
+		// age is how long before verification the cookie was issued.
+		age    time.Duration
+		mac    net.HardwareAddr
+		forge  bool
+		wantOK bool
+	}{
+		{name: "fresh", mac: mac, wantOK: true},
+		{name: "previous secret", age: 90 * time.Second, mac: mac, wantOK: true},
+		{name: "expired", age: 150 * time.Second, mac: mac},
+		{name: "other client", mac: other},
+		{name: "forged", mac: mac, forge: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := mustNewCookieJar(t, 16)
+			j.rotated = time.Now().Add(-test.age)
+			cookie := j.sign(j.current, mac)
+			if test.forge {
+				cookie[0] ^= 0xff
+			}
+			ok, err := j.verify(test.mac, cookie)
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if ok != test.wantOK {
+				t.Errorf("verify = %v, want %v", ok, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestCookieJarRotate(t *testing.T) {
+	tests := []struct {
+		name         string
+		elapsed      time.Duration
+		wantRotated  bool
+		wantPrevious bool
+	}{
+		{"within lifetime", 30 * time.Second, false, false},
+		{"after one lifetime", 90 * time.Second, true, true},
+		{"after two lifetimes", 150 * time.Second, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j := mustNewCookieJar(t, 16)
+			start := j.rotated
+			secret := j.current
+			if err := j.rotate(start.Add(test.elapsed)); err != nil {
+				t.Fatalf("rotate: %v", err)
+			}
+			if rotated := string(j.current) != string(secret); rotated != test.wantRotated {
+				t.Errorf("rotated = %v, want %v", rotated, test.wantRotated)
+			}
+			if test.wantPrevious && string(j.previous) != string(secret) {
+				t.Error("previous secret not kept")
+			}
+			if !test.wantPrevious && j.previous != nil {
+				t.Error("previous secret kept")
+			}
+		})
+	}
+}
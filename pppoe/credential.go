@@ -0,0 +1,65 @@
+package pppoe
+
+import (
+	"fmt"
+	"github.com/google/gopacket/layers"
+)
+
+type Credential struct {
+	Protocol   layers.PPPType
+	Algorithm  byte
+	Username   string
+	Password   string
+	Identifier byte
+	Challenge  []byte
+	Response   []byte
+}
+
+// Hash returns the captured challenge/response in a format accepted by
+// offline cracking tools, or an empty string for clear-text credentials.
+func (c *Credential) Hash() string {
+	switch c.Protocol {
+	case PPPTypeChallengeAuthentication:
+		switch c.Algorithm {
+		case ChallengeAlgorithmMD5:
+			// hashcat mode 4800: MD5(id || secret || challenge)
+			return fmt.Sprintf("%x:%x:%02x", c.Response, c.Challenge, c.Identifier)
+		case ChallengeAlgorithmMSCHAPv1, ChallengeAlgorithmMSCHAPv2:
+			if len(c.Response) != msChapResponseLen {
+				return ""
+			}
+			// NETNTLMv1 (hashcat mode 5500, john netntlm): user::::nt-response:challenge
+			ntResponse := c.Response[msChapNTResponseOffset : msChapNTResponseOffset+msChapNTResponseLen]
+			challenge := c.Challenge
+			if c.Algorithm == ChallengeAlgorithmMSCHAPv2 {
+				challenge = msChapV2ChallengeHash(c.Response[:msChapV2PeerChallengeLen], c.Challenge, c.Username)
+			}
+			return fmt.Sprintf("%s::::%x:%x", c.Username, ntResponse, challenge)
+		}
+	case PPPTypeEAP:
+		if c.Algorithm == byte(EAPTypeMD5Challenge) {
+			// EAP-MD5 hashes like CHAP-MD5 (RFC 3748 section 5.4)
+			return fmt.Sprintf("%x:%x:%02x", c.Response, c.Challenge, c.Identifier)
+		}
+	}
+	return ""
+}
+
+func (c *Credential) String() string {
+	if c.Protocol == PPPTypePasswordAuthentication {
+		return fmt.Sprintf("Username: %s, Password: %s", c.Username, c.Password)
+	}
+	if hash := c.Hash(); hash != "" {
+		return fmt.Sprintf("Username: %s, Hash: %s", c.Username, hash)
+	}
+	return fmt.Sprintf("Username: %s", c.Username)
+}
+
+// AuthProtocol returns the authentication protocol the credential was
+// captured with.
+func (c *Credential) AuthProtocol() AuthProtocol {
+	if c.Protocol == PPPTypeChallengeAuthentication {
+		return AuthProtocol{c.Protocol, c.Algorithm}
+	}
+	return AuthProtocol{Type: c.Protocol}
+}
@@ -0,0 +1,145 @@
+package pppoe
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func mustDecodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// msChapResponse returns an MS-CHAP response value with the given first
+// field (the LM response or the MS-CHAPv2 peer challenge) and NT-Response.
+func msChapResponse(first []byte, ntResponse []byte) []byte {
+	response := make([]byte, msChapResponseLen)
+	copy(response, first)
+	copy(response[msChapNTResponseOffset:], ntResponse)
+	return response
+}
+
+func TestCredentialHash(t *testing.T) {
+	md5Challenge := mustDecodeHex("000102030405060708090a0b0c0d0e0f")
+	md5Response := mustDecodeHex("f0e1d2c3b4a5968778695a4b3c2d1e0f")
+	// RFC 2759 section 9.2.
+	v2AuthChallenge := mustDecodeHex("5B5D7C7D7B3F2F3E3C2C602132262628")
+	v2PeerChallenge := mustDecodeHex("21402324255E262A28295F2B3A337C7E")
+	v2NTResponse := mustDecodeHex("82309ECD8D708B5EA08FAA3981CD83544233114A3D85D6DF")
+	v1Challenge := mustDecodeHex("102db5df085d3041")
+	v1NTResponse := mustDecodeHex("4e9d3c8f9cfd385d5bf4d3246791956ca4c351ab409a3d61")
+	tests := []struct {
+		name       string
+		credential *Credential
+		wantHash   string
+		wantString string
+	}{
+		{
+			name:       "PAP",
+			credential: &Credential{Protocol: PPPTypePasswordAuthentication, Username: "user", Password: "password"},
+			wantString: "Username: user, Password: password",
+		},
+		{
+			name: "CHAP-MD5",
+			credential: &Credential{
+				Protocol:   PPPTypeChallengeAuthentication,
+				Algorithm:  ChallengeAlgorithmMD5,
+				Username:   "user",
+				Identifier: 0x2a,
+				Challenge:  md5Challenge,
+				Response:   md5Response,
+			},
+			wantHash: "f0e1d2c3b4a5968778695a4b3c2d1e0f:000102030405060708090a0b0c0d0e0f:2a",
+		},
+		{
+			name: "MS-CHAPv1",
+			credential: &Credential{
+				Protocol:  PPPTypeChallengeAuthentication,
+				Algorithm: ChallengeAlgorithmMSCHAPv1,
+				Username:  "user",
+				Challenge: v1Challenge,
+				Response:  msChapResponse(nil, v1NTResponse),
+			},
+			wantHash: "user::::4e9d3c8f9cfd385d5bf4d3246791956ca4c351ab409a3d61:102db5df085d3041",
+		},
+		{
+			name: "MS-CHAPv2",
+			credential: &Credential{
+				Protocol:  PPPTypeChallengeAuthentication,
+				Algorithm: ChallengeAlgorithmMSCHAPv2,
+				Username:  "User",
+				Challenge: v2AuthChallenge,
+				Response:  msChapResponse(v2PeerChallenge, v2NTResponse),
+			},
+			wantHash: "User::::82309ecd8d708b5ea08faa3981cd83544233114a3d85d6df:d02e4386bce91226",
+		},
+		{
+			name: "MS-CHAPv2 with domain",
+			credential: &Credential{
+				Protocol:  PPPTypeChallengeAuthentication,
+				Algorithm: ChallengeAlgorithmMSCHAPv2,
+				Username:  `DOMAIN\User`,
+				Challenge: v2AuthChallenge,
+				Response:  msChapResponse(v2PeerChallenge, v2NTResponse),
+			},
+			wantHash: `DOMAIN\User::::82309ecd8d708b5ea08faa3981cd83544233114a3d85d6df:d02e4386bce91226`,
+		},
+		{
+			name: "MS-CHAP truncated",
+			credential: &Credential{
+				Protocol:  PPPTypeChallengeAuthentication,
+				Algorithm: ChallengeAlgorithmMSCHAPv2,
+				Username:  "user",
+				Challenge: v2AuthChallenge,
+				Response:  v2NTResponse,
+			},
+			wantString: "Username: user",
+		},
+		{
+			name: "EAP-MD5",
+			credential: &Credential{
+				Protocol:   PPPTypeEAP,
+				Algorithm:  byte(EAPTypeMD5Challenge),
+				Username:   "user",
+				Identifier: 0x2a,
+				Challenge:  md5Challenge,
+				Response:   md5Response,
+			},
+			wantHash: "f0e1d2c3b4a5968778695a4b3c2d1e0f:000102030405060708090a0b0c0d0e0f:2a",
+		},
+		{
+			name:       "EAP without MD5",
+			credential: &Credential{Protocol: PPPTypeEAP, Algorithm: 26, Username: "user"},
+			wantString: "Username: user",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.credential.Hash(); got != test.wantHash {
+				t.Errorf("Hash = %q, want %q", got, test.wantHash)
+			}
+			wantString := test.wantString
+			if wantString == "" {
+				wantString = "Username: " + test.credential.Username + ", Hash: " + test.wantHash
+			}
+			if got := test.credential.String(); got != wantString {
+				t.Errorf("String = %q, want %q", got, wantString)
+			}
+		})
+	}
+}
+
+func TestMSCHAPv2ChallengeHash(t *testing.T) {
+	authChallenge := mustDecodeHex("5B5D7C7D7B3F2F3E3C2C602132262628")
+	peerChallenge := mustDecodeHex("21402324255E262A28295F2B3A337C7E")
+	want := mustDecodeHex("D02E4386BCE91226")
+	for _, username := range []string{"User", `DOMAIN\User`} {
+		if got := msChapV2ChallengeHash(peerChallenge, authChallenge, username); !bytes.Equal(got, want) {
+			t.Errorf("msChapV2ChallengeHash(%q) = %x, want %x", username, got, want)
+		}
+	}
+}
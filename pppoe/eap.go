@@ -0,0 +1,148 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+)
+
+type PPPEAPCode byte
+
+const (
+	EAPRequest  PPPEAPCode = 1
+	EAPResponse PPPEAPCode = 2
+	EAPSuccess  PPPEAPCode = 3
+	EAPFailure  PPPEAPCode = 4
+)
+
+func (c PPPEAPCode) String() string {
+	switch c {
+	case EAPRequest:
+		return "Request"
+	case EAPResponse:
+		return "Response"
+	case EAPSuccess:
+		return "Success"
+	case EAPFailure:
+		return "Failure"
+	}
+	return fmt.Sprintf("Unknown Code %d", c)
+}
+
+type EAPType byte
+
+const (
+	EAPTypeIdentity     EAPType = 1
+	EAPTypeNotification EAPType = 2
+	EAPTypeNak          EAPType = 3
+	EAPTypeMD5Challenge EAPType = 4
+)
+
+type PPPEAPTypeDataOption struct {
+	Data []byte
+}
+
+func (m *PPPEAPTypeDataOption) Content() []byte {
+	return m.Data
+}
+
+func (m *PPPEAPTypeDataOption) Len() int {
+	return len(m.Data)
+}
+
+type PPPEAP struct {
+	Code       PPPEAPCode
+	Identifier byte
+	Length     uint16
+	Type       EAPType
+	Options    []Option
+}
+
+var LayerTypePPPEAP = gopacket.RegisterLayerType(
+	2004,
+	gopacket.LayerTypeMetadata{
+		Name:    "LayerTypePPPEAP",
+		Decoder: gopacket.DecodeFunc(nil),
+	},
+)
+
+func (m *PPPEAP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	headerLen := 4
+	if m.Code == EAPRequest || m.Code == EAPResponse {
+		headerLen = 5
+	}
+	bytes, err := b.AppendBytes(headerLen)
+	if err != nil {
+		return err
+	}
+	bytes[0] = byte(m.Code)
+	bytes[1] = m.Identifier
+	binary.BigEndian.PutUint16(bytes[2:], m.Length)
+	if headerLen == 5 {
+		bytes[4] = byte(m.Type)
+	}
+	for _, op := range m.Options {
+		bytes, err = b.AppendBytes(op.Len())
+		if err != nil {
+			return err
+		}
+		copy(bytes, op.Content())
+	}
+	return nil
+}
+
+func (m *PPPEAP) LayerType() gopacket.LayerType {
+	return LayerTypePPPEAP
+}
+
+func (m *PPPEAP) DecodeFromBytes(data []byte) {
+	m.Code = PPPEAPCode(data[0])
+	m.Identifier = data[1]
+	m.Length = binary.BigEndian.Uint16(data[2:4])
+	if int(m.Length) > len(data) || m.Length < 4 {
+		m.Length = uint16(len(data))
+	}
+	m.Options = make([]Option, 0)
+	if (m.Code == EAPRequest || m.Code == EAPResponse) && m.Length > 4 {
+		m.Type = EAPType(data[4])
+		switch m.Type {
+		case EAPTypeMD5Challenge:
+			m.Options = DecodePPPChallengeValueOption(data[5:m.Length])
+		default:
+			m.Options = []Option{&PPPEAPTypeDataOption{Data: data[5:m.Length]}}
+		}
+	}
+}
+
+func (s *Server) sendPPPEAP(dst net.HardwareAddr, code PPPEAPCode, sid uint16, id byte, eapType EAPType, options []Option) {
+	payload := encodePPPEAP(code, id, eapType, options)
+	s.sendPacket(dst, payload, layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(payload)))
+}
+
+func encodePPPEAP(code PPPEAPCode, id byte, eapType EAPType, options []Option) []byte {
+	length := 4
+	if code == EAPRequest || code == EAPResponse {
+		length++
+	}
+	for _, op := range options {
+		length += op.Len()
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	so := gopacket.SerializeOptions{}
+	gopacket.SerializeLayers(buffer, so,
+		&layers.PPP{
+			PPPType: PPPTypeEAP,
+		},
+		&PPPEAP{
+			Code:       code,
+			Identifier: id,
+			Length:     uint16(length),
+			Type:       eapType,
+			Options:    options,
+		},
+	)
+	return buffer.Bytes()
+}
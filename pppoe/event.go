@@ -0,0 +1,168 @@
+package pppoe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventDiscovery   EventType = "discovery"
+	EventLCP         EventType = "lcp"
+	EventAuth        EventType = "auth"
+	EventNCP         EventType = "ncp"
+	EventTermination EventType = "termination"
+	EventError       EventType = "error"
+)
+
+type EventDirection string
+
+const (
+	EventIncoming EventDirection = "in"
+	EventOutgoing EventDirection = "out"
+)
+
+// eventBufferSize is the capacity of the channel returned by Server.Serve.
+const eventBufferSize = 64
+
+// Log formats of Config.LogFormat.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Event describes one step of a session: a packet received or sent, a
+// captured credential, or an error. Fields holds the decoded values of the
+// step, e.g. the username and hash of a credential, and Credential is set
+// when one has been captured.
+type Event struct {
+	Time       time.Time
+	Type       EventType
+	Direction  EventDirection
+	Local      net.HardwareAddr
+	Peer       net.HardwareAddr
+	SessionID  uint16
+	Protocol   string
+	Message    string
+	Fields     map[string]interface{}
+	Credential *Credential
+}
+
+// eventTypes maps the protocol names used in the trace to event types.
+var eventTypes = map[string]EventType{
+	"PPPoED":     EventDiscovery,
+	"PPP LCP":    EventLCP,
+	"PPP PAP":    EventAuth,
+	"PPP CHAP":   EventAuth,
+	"PPP EAP":    EventAuth,
+	"PPP IPCP":   EventNCP,
+	"PPP IPV6CP": EventNCP,
+}
+
+func macString(mac net.HardwareAddr) string {
+	if mac == nil {
+		return ""
+	}
+	return mac.String()
+}
+
+func (e *Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Time      string                 `json:"time"`
+		Type      EventType              `json:"type"`
+		Direction EventDirection         `json:"direction,omitempty"`
+		Local     string                 `json:"local,omitempty"`
+		Peer      string                 `json:"peer,omitempty"`
+		SessionID uint16                 `json:"session_id,omitempty"`
+		Protocol  string                 `json:"protocol,omitempty"`
+		Message   string                 `json:"message,omitempty"`
+		Fields    map[string]interface{} `json:"fields,omitempty"`
+	}{
+		Time:      e.Time.Format(time.RFC3339Nano),
+		Type:      e.Type,
+		Direction: e.Direction,
+		Local:     macString(e.Local),
+		Peer:      macString(e.Peer),
+		SessionID: e.SessionID,
+		Protocol:  e.Protocol,
+		Message:   e.Message,
+		Fields:    e.Fields,
+	})
+}
+
+// String renders the event as a line of the text trace.
+func (e *Event) String() string {
+	switch {
+	case e.Type == EventError:
+		return fmt.Sprintf("ERROR: %s\n", e.Message)
+	case e.Direction == EventOutgoing:
+		return fmt.Sprintf(outgoingFormat, formatTime(e.Time), e.Local, e.Peer, e.Protocol, e.Message)
+	}
+	return fmt.Sprintf(incomingFormat, formatTime(e.Time), e.Local, e.Peer, e.Protocol, e.Message)
+}
+
+// credentialFields returns the decoded values of a captured credential.
+func credentialFields(credential *Credential) map[string]interface{} {
+	fields := map[string]interface{}{
+		"auth_protocol": credential.AuthProtocol().String(),
+		"username":      credential.Username,
+	}
+	if credential.Protocol == PPPTypePasswordAuthentication {
+		fields["password"] = credential.Password
+	}
+	if hash := credential.Hash(); hash != "" {
+		fields["hash"] = hash
+	}
+	return fields
+}
+
+// emit completes an event, delivers it to the channel returned by Serve
+// and writes it to the log in the configured format.
+func (s *Server) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Local == nil {
+		e.Local = s.mac
+	}
+	if e.Type == "" {
+		e.Type = eventTypes[e.Protocol]
+	}
+	if s.events != nil {
+		select {
+		case s.events <- e:
+		case <-s.stop:
+		}
+	}
+	writeEvent(s.config.LogOutput, s.config.LogFormat, &e)
+}
+
+// writeEvent writes an event to a log in the given format.
+func writeEvent(w io.Writer, format string, e *Event) {
+	switch format {
+	case LogFormatJSON:
+		data, err := json.Marshal(e)
+		if err != nil {
+			return
+		}
+		w.Write(append(data, '\n'))
+	default:
+		fmt.Fprint(w, e.String())
+	}
+}
+
+func (s *Server) logIncoming(src net.HardwareAddr, sid uint16, protocol string, message interface{}) {
+	s.emit(Event{Direction: EventIncoming, Peer: src, SessionID: sid, Protocol: protocol, Message: fmt.Sprint(message)})
+}
+
+func (s *Server) logOutgoing(dst net.HardwareAddr, sid uint16, protocol string, message interface{}) {
+	s.emit(Event{Direction: EventOutgoing, Peer: dst, SessionID: sid, Protocol: protocol, Message: fmt.Sprint(message)})
+}
+
+func (s *Server) logError(err error) {
+	s.emit(Event{Type: EventError, Message: err.Error()})
+}
@@ -0,0 +1,409 @@
+package pppoe
+
+import (
+	"time"
+)
+
+// PPPState is a state of the option negotiation automaton described in
+// RFC 1661 section 4.
+type PPPState int
+
+const (
+	PPPStateInitial PPPState = iota
+	PPPStateStarting
+	PPPStateClosed
+	PPPStateStopped
+	PPPStateClosing
+	PPPStateStopping
+	PPPStateReqSent
+	PPPStateAckRcvd
+	PPPStateAckSent
+	PPPStateOpened
+)
+
+var pppStateNames = []string{
+	"Initial", "Starting", "Closed", "Stopped", "Closing",
+	"Stopping", "Req-Sent", "Ack-Rcvd", "Ack-Sent", "Opened",
+}
+
+func (s PPPState) String() string {
+	if int(s) < len(pppStateNames) {
+		return pppStateNames[s]
+	}
+	return "Unknown"
+}
+
+const (
+	DefaultRestartTimer = 3 * time.Second
+	DefaultMaxConfigure = 10
+	DefaultMaxTerminate = 2
+	DefaultMaxFailure   = 5
+)
+
+// negotiationHandler supplies the protocol specific parts of a negotiator,
+// i.e. the options of LCP or one of the NCPs.
+type negotiationHandler interface {
+	// requestOptions returns the options of the next Configure-Request.
+	requestOptions() []Option
+	// checkRequest inspects the options of a Configure-Request received from
+	// the peer and returns the reply code together with the options to send.
+	checkRequest(options []Option) (PPPLCPCode, []Option)
+	// nakReceived and rejectReceived adjust the requested options to the
+	// peer's reply and return false if negotiation cannot converge.
+	nakReceived(options []Option) bool
+	rejectReceived(options []Option) bool
+	layerUp()
+	layerDown()
+	layerFinished()
+}
+
+type negotiator struct {
+	handler negotiationHandler
+	send    func(code PPPLCPCode, id byte, options []Option)
+
+	RestartTimer time.Duration
+	MaxConfigure int
+	MaxTerminate int
+	MaxFailure   int
+
+	state        PPPState
+	restartCount int
+	failureCount int
+	deadline     time.Time
+	id           byte
+	// rejectID numbers the Code-Rejects and Protocol-Rejects apart from id
+	// so that they do not invalidate a pending Configure-Request.
+	rejectID byte
+}
+
+func newNegotiator(handler negotiationHandler, send func(code PPPLCPCode, id byte, options []Option)) *negotiator {
+	return &negotiator{
+		handler:      handler,
+		send:         send,
+		RestartTimer: DefaultRestartTimer,
+		MaxConfigure: DefaultMaxConfigure,
+		MaxTerminate: DefaultMaxTerminate,
+		MaxFailure:   DefaultMaxFailure,
+		id:           GenerateRandomBytes(1)[0],
+		rejectID:     GenerateRandomBytes(1)[0],
+	}
+}
+
+func (n *negotiator) State() PPPState {
+	return n.state
+}
+
+func (n *negotiator) initializeRestartCount(maxCount int) {
+	n.restartCount = maxCount
+}
+
+func (n *negotiator) zeroRestartCount() {
+	n.restartCount = 0
+	n.deadline = time.Now().Add(n.RestartTimer)
+}
+
+// nextRejectID returns the identifier of the next Code-Reject or
+// Protocol-Reject.
+func (n *negotiator) nextRejectID() byte {
+	n.rejectID++
+	return n.rejectID
+}
+
+func (n *negotiator) sendConfigureRequest() {
+	n.id++
+	n.restartCount--
+	n.deadline = time.Now().Add(n.RestartTimer)
+	n.send(PPPLCPCodeConfigurationRequest, n.id, n.handler.requestOptions())
+}
+
+func (n *negotiator) sendTerminateRequest() {
+	n.id++
+	n.restartCount--
+	n.deadline = time.Now().Add(n.RestartTimer)
+	n.send(PPPLCPCodeTerminateRequest, n.id, []Option{&PPPLCPTerminateOption{Data: make([]byte, 0)}})
+}
+
+func (n *negotiator) sendTerminateAck(id byte) {
+	n.send(PPPLCPCodeTerminateAck, id, []Option{&PPPLCPTerminateOption{Data: make([]byte, 0)}})
+}
+
+func (n *negotiator) layerUp() {
+	n.deadline = time.Time{}
+	n.state = PPPStateOpened
+	n.handler.layerUp()
+}
+
+func (n *negotiator) layerFinished(state PPPState) {
+	n.deadline = time.Time{}
+	n.state = state
+	n.handler.layerFinished()
+}
+
+// Up signals that the lower layer is ready to carry packets.
+func (n *negotiator) Up() {
+	switch n.state {
+	case PPPStateInitial:
+		n.state = PPPStateClosed
+	case PPPStateStarting:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	}
+}
+
+// Down signals that the lower layer is no longer available.
+func (n *negotiator) Down() {
+	n.deadline = time.Time{}
+	switch n.state {
+	case PPPStateClosed, PPPStateClosing:
+		n.state = PPPStateInitial
+	case PPPStateStopped, PPPStateStopping, PPPStateReqSent, PPPStateAckRcvd, PPPStateAckSent:
+		n.state = PPPStateStarting
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.state = PPPStateStarting
+	}
+}
+
+// Open administratively allows the link to be negotiated.
+func (n *negotiator) Open() {
+	switch n.state {
+	case PPPStateInitial:
+		n.state = PPPStateStarting
+	case PPPStateClosed:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	case PPPStateClosing:
+		n.state = PPPStateStopping
+	}
+}
+
+// Close administratively terminates the link.
+func (n *negotiator) Close() {
+	switch n.state {
+	case PPPStateStarting:
+		n.layerFinished(PPPStateInitial)
+	case PPPStateStopped:
+		n.state = PPPStateClosed
+	case PPPStateStopping:
+		n.state = PPPStateClosing
+	case PPPStateOpened:
+		n.handler.layerDown()
+		fallthrough
+	case PPPStateReqSent, PPPStateAckRcvd, PPPStateAckSent:
+		n.initializeRestartCount(n.MaxTerminate)
+		n.sendTerminateRequest()
+		n.state = PPPStateClosing
+	}
+}
+
+// Tick expires the restart timer if its deadline has passed.
+func (n *negotiator) Tick(now time.Time) {
+	if n.deadline.IsZero() || now.Before(n.deadline) {
+		return
+	}
+	n.deadline = time.Time{}
+	if n.restartCount > 0 {
+		switch n.state {
+		case PPPStateClosing, PPPStateStopping:
+			n.sendTerminateRequest()
+		case PPPStateReqSent, PPPStateAckRcvd:
+			n.sendConfigureRequest()
+			n.state = PPPStateReqSent
+		case PPPStateAckSent:
+			n.sendConfigureRequest()
+		}
+		return
+	}
+	switch n.state {
+	case PPPStateClosing:
+		n.layerFinished(PPPStateClosed)
+	case PPPStateStopping, PPPStateReqSent, PPPStateAckRcvd, PPPStateAckSent:
+		n.layerFinished(PPPStateStopped)
+	}
+}
+
+// Input feeds a received packet of one of the codes shared by LCP and the
+// NCPs into the automaton. data holds the whole packet and is echoed back in
+// a Code-Reject when the code is unknown.
+func (n *negotiator) Input(code PPPLCPCode, id byte, options []Option, data []byte) {
+	if n.state == PPPStateInitial || n.state == PPPStateStarting {
+		return
+	}
+	switch code {
+	case PPPLCPCodeConfigurationRequest:
+		n.receiveConfigureRequest(id, options)
+	case PPPLCPCodeConfigurationAck:
+		if id == n.id {
+			n.receiveConfigureAck()
+		}
+	case PPPLCPCodeConfigurationNak, PPPLCPCodeConfigurationReject:
+		if id != n.id {
+			return
+		}
+		var ok bool
+		if code == PPPLCPCodeConfigurationNak {
+			ok = n.handler.nakReceived(options)
+		} else {
+			ok = n.handler.rejectReceived(options)
+		}
+		if !ok {
+			n.Close()
+			return
+		}
+		n.receiveConfigureNak()
+	case PPPLCPCodeTerminateRequest:
+		n.receiveTerminateRequest(id)
+	case PPPLCPCodeTerminateAck:
+		n.receiveTerminateAck()
+	case PPPLCPCodeCodeReject:
+		rejected := PPPLCPCode(0)
+		if len(data) > 4 {
+			rejected = PPPLCPCode(data[4])
+		}
+		n.RejectReceived(rejected < PPPLCPCodeConfigurationRequest || rejected > PPPLCPCodeCodeReject)
+	default:
+		n.send(PPPLCPCodeCodeReject, n.nextRejectID(), []Option{&PPPLCPRejectOption{Data: data}})
+	}
+}
+
+func (n *negotiator) receiveConfigureRequest(id byte, options []Option) {
+	switch n.state {
+	case PPPStateClosed:
+		n.sendTerminateAck(id)
+		return
+	case PPPStateClosing, PPPStateStopping:
+		return
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.sendConfigureRequest()
+	case PPPStateStopped:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.sendConfigureRequest()
+	}
+	code, reply := n.handler.checkRequest(options)
+	if code == PPPLCPCodeConfigurationNak {
+		n.failureCount++
+		if n.failureCount > n.MaxFailure {
+			code, reply = PPPLCPCodeConfigurationReject, naksToRejects(options, reply)
+		}
+	}
+	n.send(code, id, reply)
+	if code == PPPLCPCodeConfigurationAck {
+		n.failureCount = 0
+		switch n.state {
+		case PPPStateAckRcvd:
+			n.layerUp()
+		case PPPStateOpened, PPPStateStopped, PPPStateReqSent:
+			n.state = PPPStateAckSent
+		}
+		return
+	}
+	switch n.state {
+	case PPPStateOpened, PPPStateStopped, PPPStateAckSent:
+		n.state = PPPStateReqSent
+	}
+}
+
+func (n *negotiator) receiveConfigureAck() {
+	switch n.state {
+	case PPPStateClosed, PPPStateStopped:
+		n.sendTerminateAck(n.id)
+	case PPPStateReqSent:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.state = PPPStateAckRcvd
+	case PPPStateAckRcvd:
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	case PPPStateAckSent:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.layerUp()
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	}
+}
+
+func (n *negotiator) receiveConfigureNak() {
+	switch n.state {
+	case PPPStateClosed, PPPStateStopped:
+		n.sendTerminateAck(n.id)
+	case PPPStateReqSent, PPPStateAckSent:
+		n.initializeRestartCount(n.MaxConfigure)
+		n.sendConfigureRequest()
+	case PPPStateAckRcvd:
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	}
+}
+
+func (n *negotiator) receiveTerminateRequest(id byte) {
+	switch n.state {
+	case PPPStateAckRcvd, PPPStateAckSent:
+		n.state = PPPStateReqSent
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.zeroRestartCount()
+		n.state = PPPStateStopping
+	}
+	n.sendTerminateAck(id)
+}
+
+func (n *negotiator) receiveTerminateAck() {
+	switch n.state {
+	case PPPStateClosing:
+		n.layerFinished(PPPStateClosed)
+	case PPPStateStopping:
+		n.layerFinished(PPPStateStopped)
+	case PPPStateAckRcvd:
+		n.state = PPPStateReqSent
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.sendConfigureRequest()
+		n.state = PPPStateReqSent
+	}
+}
+
+// RejectReceived handles a Code-Reject or Protocol-Reject. A permitted reject
+// only concerns an optional feature, while a catastrophic one means the link
+// cannot work and is torn down.
+func (n *negotiator) RejectReceived(permitted bool) {
+	if permitted {
+		if n.state == PPPStateAckRcvd {
+			n.state = PPPStateReqSent
+		}
+		return
+	}
+	switch n.state {
+	case PPPStateClosed, PPPStateClosing:
+		n.layerFinished(PPPStateClosed)
+	case PPPStateStopped, PPPStateStopping, PPPStateReqSent, PPPStateAckRcvd, PPPStateAckSent:
+		n.layerFinished(PPPStateStopped)
+	case PPPStateOpened:
+		n.handler.layerDown()
+		n.initializeRestartCount(n.MaxTerminate)
+		n.sendTerminateRequest()
+		n.state = PPPStateStopping
+	}
+}
+
+// naksToRejects turns a Configure-Nak reply into a Configure-Reject carrying
+// the original values of the Nak'd options once Max-Failure is exceeded.
+func naksToRejects(options []Option, naks []Option) []Option {
+	rejects := make([]Option, 0)
+	for i, op := range options {
+		for _, nak := range naks {
+			if op.Content()[0] == nak.Content()[0] {
+				rejects = append(rejects, options[i])
+				break
+			}
+		}
+	}
+	return rejects
+}
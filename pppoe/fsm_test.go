@@ -0,0 +1,243 @@
+package pppoe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fsmActionNames abbreviates the packets sent by a negotiator like the
+// actions of RFC 1661 section 4.1.
+var fsmActionNames = map[PPPLCPCode]string{
+	PPPLCPCodeConfigurationRequest: "scr",
+	PPPLCPCodeConfigurationAck:     "sca",
+	PPPLCPCodeConfigurationNak:     "scn",
+	PPPLCPCodeConfigurationReject:  "scrj",
+	PPPLCPCodeTerminateRequest:     "str",
+	PPPLCPCodeTerminateAck:         "sta",
+	PPPLCPCodeCodeReject:           "scj",
+}
+
+// fsmRecorder is a negotiationHandler recording the actions of the
+// negotiator, packets sent and layer events alike.
+type fsmRecorder struct {
+	// reply is the code checkRequest answers with.
+	reply   PPPLCPCode
+	actions []string
+	ids     []byte
+}
+
+func (r *fsmRecorder) send(code PPPLCPCode, id byte, options []Option) {
+	r.actions = append(r.actions, fsmActionNames[code])
+	r.ids = append(r.ids, id)
+}
+
+func (r *fsmRecorder) requestOptions() []Option {
+	return nil
+}
+
+func (r *fsmRecorder) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	return r.reply, options
+}
+
+func (r *fsmRecorder) nakReceived(options []Option) bool {
+	return true
+}
+
+func (r *fsmRecorder) rejectReceived(options []Option) bool {
+	return true
+}
+
+func (r *fsmRecorder) layerUp() {
+	r.actions = append(r.actions, "tlu")
+}
+
+func (r *fsmRecorder) layerDown() {
+	r.actions = append(r.actions, "tld")
+}
+
+func (r *fsmRecorder) layerFinished() {
+	r.actions = append(r.actions, "tlf")
+}
+
+// fsmEvents are the events of RFC 1661 section 4.1 applied to a negotiator.
+var fsmEvents = map[string]func(n *negotiator, r *fsmRecorder){
+	"up":    func(n *negotiator, r *fsmRecorder) { n.Up() },
+	"down":  func(n *negotiator, r *fsmRecorder) { n.Down() },
+	"open":  func(n *negotiator, r *fsmRecorder) { n.Open() },
+	"close": func(n *negotiator, r *fsmRecorder) { n.Close() },
+	"to+": func(n *negotiator, r *fsmRecorder) {
+		n.restartCount = 1
+		n.deadline = time.Now()
+		n.Tick(n.deadline)
+	},
+	"to-": func(n *negotiator, r *fsmRecorder) {
+		n.restartCount = 0
+		n.deadline = time.Now()
+		n.Tick(n.deadline)
+	},
+	"rcr+": func(n *negotiator, r *fsmRecorder) {
+		r.reply = PPPLCPCodeConfigurationAck
+		n.Input(PPPLCPCodeConfigurationRequest, 1, nil, nil)
+	},
+	"rcr-": func(n *negotiator, r *fsmRecorder) {
+		r.reply = PPPLCPCodeConfigurationNak
+		n.Input(PPPLCPCodeConfigurationRequest, 1, nil, nil)
+	},
+	"rca": func(n *negotiator, r *fsmRecorder) { n.Input(PPPLCPCodeConfigurationAck, n.id, nil, nil) },
+	"rcn": func(n *negotiator, r *fsmRecorder) { n.Input(PPPLCPCodeConfigurationNak, n.id, nil, nil) },
+	"rtr": func(n *negotiator, r *fsmRecorder) { n.Input(PPPLCPCodeTerminateRequest, 1, nil, nil) },
+	"rta": func(n *negotiator, r *fsmRecorder) { n.Input(PPPLCPCodeTerminateAck, n.id, nil, nil) },
+	// A Code-Reject of Echo-Request is permitted, one of Configure-Request
+	// is catastrophic.
+	"rxj+": func(n *negotiator, r *fsmRecorder) {
+		n.Input(PPPLCPCodeCodeReject, 1, nil, []byte{byte(PPPLCPCodeCodeReject), 1, 0, 8, byte(PPPLCPCodeEchoRequest), 1, 0, 4})
+	},
+	"rxj-": func(n *negotiator, r *fsmRecorder) {
+		n.Input(PPPLCPCodeCodeReject, 1, nil, []byte{byte(PPPLCPCodeCodeReject), 1, 0, 8, byte(PPPLCPCodeConfigurationRequest), 1, 0, 4})
+	},
+	"ruc": func(n *negotiator, r *fsmRecorder) { n.Input(PPPLCPCode(42), 1, nil, []byte{42, 1, 0, 4}) },
+}
+
+func TestNegotiatorStateTable(t *testing.T) {
+	tests := []struct {
+		state       PPPState
+		event       string
+		wantState   PPPState
+		wantActions []string
+	}{
+		{PPPStateInitial, "up", PPPStateClosed, nil},
+		{PPPStateInitial, "open", PPPStateStarting, nil},
+		{PPPStateInitial, "close", PPPStateInitial, nil},
+		{PPPStateInitial, "rcr+", PPPStateInitial, nil},
+
+		{PPPStateStarting, "up", PPPStateReqSent, []string{"scr"}},
+		{PPPStateStarting, "close", PPPStateInitial, []string{"tlf"}},
+		{PPPStateStarting, "rcr+", PPPStateStarting, nil},
+
+		{PPPStateClosed, "down", PPPStateInitial, nil},
+		{PPPStateClosed, "open", PPPStateReqSent, []string{"scr"}},
+		{PPPStateClosed, "rcr+", PPPStateClosed, []string{"sta"}},
+		{PPPStateClosed, "rca", PPPStateClosed, []string{"sta"}},
+		{PPPStateClosed, "rtr", PPPStateClosed, []string{"sta"}},
+		{PPPStateClosed, "rta", PPPStateClosed, nil},
+		{PPPStateClosed, "rxj-", PPPStateClosed, []string{"tlf"}},
+		{PPPStateClosed, "ruc", PPPStateClosed, []string{"scj"}},
+
+		{PPPStateStopped, "down", PPPStateStarting, nil},
+		{PPPStateStopped, "close", PPPStateClosed, nil},
+		{PPPStateStopped, "rcr+", PPPStateAckSent, []string{"scr", "sca"}},
+		{PPPStateStopped, "rcr-", PPPStateReqSent, []string{"scr", "scn"}},
+		{PPPStateStopped, "rca", PPPStateStopped, []string{"sta"}},
+		{PPPStateStopped, "rtr", PPPStateStopped, []string{"sta"}},
+		{PPPStateStopped, "rxj-", PPPStateStopped, []string{"tlf"}},
+
+		{PPPStateClosing, "down", PPPStateInitial, nil},
+		{PPPStateClosing, "open", PPPStateStopping, nil},
+		{PPPStateClosing, "to+", PPPStateClosing, []string{"str"}},
+		{PPPStateClosing, "to-", PPPStateClosed, []string{"tlf"}},
+		{PPPStateClosing, "rcr+", PPPStateClosing, nil},
+		{PPPStateClosing, "rtr", PPPStateClosing, []string{"sta"}},
+		{PPPStateClosing, "rta", PPPStateClosed, []string{"tlf"}},
+		{PPPStateClosing, "rxj-", PPPStateClosed, []string{"tlf"}},
+
+		{PPPStateStopping, "down", PPPStateStarting, nil},
+		{PPPStateStopping, "close", PPPStateClosing, nil},
+		{PPPStateStopping, "to+", PPPStateStopping, []string{"str"}},
+		{PPPStateStopping, "to-", PPPStateStopped, []string{"tlf"}},
+		{PPPStateStopping, "rta", PPPStateStopped, []string{"tlf"}},
+
+		{PPPStateReqSent, "down", PPPStateStarting, nil},
+		{PPPStateReqSent, "close", PPPStateClosing, []string{"str"}},
+		{PPPStateReqSent, "to+", PPPStateReqSent, []string{"scr"}},
+		{PPPStateReqSent, "to-", PPPStateStopped, []string{"tlf"}},
+		{PPPStateReqSent, "rcr+", PPPStateAckSent, []string{"sca"}},
+		{PPPStateReqSent, "rcr-", PPPStateReqSent, []string{"scn"}},
+		{PPPStateReqSent, "rca", PPPStateAckRcvd, nil},
+		{PPPStateReqSent, "rcn", PPPStateReqSent, []string{"scr"}},
+		{PPPStateReqSent, "rtr", PPPStateReqSent, []string{"sta"}},
+		{PPPStateReqSent, "rxj+", PPPStateReqSent, nil},
+		{PPPStateReqSent, "rxj-", PPPStateStopped, []string{"tlf"}},
+		{PPPStateReqSent, "ruc", PPPStateReqSent, []string{"scj"}},
+
+		{PPPStateAckRcvd, "to+", PPPStateReqSent, []string{"scr"}},
+		{PPPStateAckRcvd, "rcr+", PPPStateOpened, []string{"sca", "tlu"}},
+		{PPPStateAckRcvd, "rcr-", PPPStateAckRcvd, []string{"scn"}},
+		{PPPStateAckRcvd, "rca", PPPStateReqSent, []string{"scr"}},
+		{PPPStateAckRcvd, "rcn", PPPStateReqSent, []string{"scr"}},
+		{PPPStateAckRcvd, "rtr", PPPStateReqSent, []string{"sta"}},
+		{PPPStateAckRcvd, "rta", PPPStateReqSent, nil},
+		{PPPStateAckRcvd, "rxj+", PPPStateReqSent, nil},
+		{PPPStateAckRcvd, "rxj-", PPPStateStopped, []string{"tlf"}},
+
+		{PPPStateAckSent, "to+", PPPStateAckSent, []string{"scr"}},
+		{PPPStateAckSent, "rcr+", PPPStateAckSent, []string{"sca"}},
+		{PPPStateAckSent, "rcr-", PPPStateReqSent, []string{"scn"}},
+		{PPPStateAckSent, "rca", PPPStateOpened, []string{"tlu"}},
+		{PPPStateAckSent, "rcn", PPPStateAckSent, []string{"scr"}},
+		{PPPStateAckSent, "rtr", PPPStateReqSent, []string{"sta"}},
+		{PPPStateAckSent, "rxj-", PPPStateStopped, []string{"tlf"}},
+
+		{PPPStateOpened, "down", PPPStateStarting, []string{"tld"}},
+		{PPPStateOpened, "close", PPPStateClosing, []string{"tld", "str"}},
+		{PPPStateOpened, "rcr+", PPPStateAckSent, []string{"tld", "scr", "sca"}},
+		{PPPStateOpened, "rcr-", PPPStateReqSent, []string{"tld", "scr", "scn"}},
+		{PPPStateOpened, "rca", PPPStateReqSent, []string{"tld", "scr"}},
+		{PPPStateOpened, "rcn", PPPStateReqSent, []string{"tld", "scr"}},
+		{PPPStateOpened, "rtr", PPPStateStopping, []string{"tld", "sta"}},
+		{PPPStateOpened, "rta", PPPStateReqSent, []string{"tld", "scr"}},
+		{PPPStateOpened, "rxj+", PPPStateOpened, nil},
+		{PPPStateOpened, "rxj-", PPPStateStopping, []string{"tld", "str"}},
+		{PPPStateOpened, "ruc", PPPStateOpened, []string{"scj"}},
+	}
+	for _, test := range tests {
+		t.Run(test.state.String()+"/"+test.event, func(t *testing.T) {
+			recorder := &fsmRecorder{}
+			n := newNegotiator(recorder, recorder.send)
+			n.state = test.state
+			fsmEvents[test.event](n, recorder)
+			if n.State() != test.wantState {
+				t.Errorf("state = %s, want %s", n.State(), test.wantState)
+			}
+			if !reflect.DeepEqual(recorder.actions, test.wantActions) {
+				t.Errorf("actions = %v, want %v", recorder.actions, test.wantActions)
+			}
+		})
+	}
+}
+
+// TestNegotiatorRejectID checks that a Code-Reject sent while a
+// Configure-Request is pending does not change the identifier the
+// Configure-Ack must match.
+func TestNegotiatorRejectID(t *testing.T) {
+	recorder := &fsmRecorder{}
+	n := newNegotiator(recorder, recorder.send)
+	n.state = PPPStateClosed
+	n.Open()
+	requestID := recorder.ids[0]
+	fsmEvents["ruc"](n, recorder)
+	if recorder.ids[1] == requestID {
+		t.Errorf("Code-Reject sent with the identifier %d of the Configure-Request", requestID)
+	}
+	n.Input(PPPLCPCodeConfigurationAck, requestID, nil, nil)
+	if n.State() != PPPStateAckRcvd {
+		t.Errorf("state = %s after the Configure-Ack, want %s", n.State(), PPPStateAckRcvd)
+	}
+}
+
+// TestNegotiatorMaxFailure checks that Configure-Naks turn into
+// Configure-Rejects once MaxFailure is exceeded.
+func TestNegotiatorMaxFailure(t *testing.T) {
+	recorder := &fsmRecorder{reply: PPPLCPCodeConfigurationNak}
+	n := newNegotiator(recorder, recorder.send)
+	n.MaxFailure = 2
+	n.state = PPPStateReqSent
+	option := &PPPLCPOption{Type: PPPLCPOptionType(1), Length: 4, Data: []byte{5, 220}}
+	for i := 0; i < 3; i++ {
+		n.Input(PPPLCPCodeConfigurationRequest, byte(i), []Option{option}, nil)
+	}
+	want := []string{"scn", "scn", "scrj"}
+	if !reflect.DeepEqual(recorder.actions, want) {
+		t.Errorf("actions = %v, want %v", recorder.actions, want)
+	}
+}
@@ -2,7 +2,6 @@ package pppoe
 
 import (
 	"encoding/csv"
-	"github.com/google/gopacket/pcap"
 	"log"
 	"net"
 	"os/exec"
@@ -12,22 +11,12 @@ import (
 )
 
 type Interface struct {
-	pcap.Interface
+	Name         string
+	Description  string
 	HardwareAddr net.HardwareAddr
 }
 
-func GetActiveInterfaces() ([]*Interface, error) {
-	// Find all devices
-	devices, err := pcap.FindAllDevs()
-	if err != nil {
-		return nil, err
-	}
-	interfaces := make([]*Interface, 0)
-	for _, d := range devices {
-		interfaces = append(interfaces, &Interface{
-			Interface: d,
-		})
-	}
+func activeInterfaces(interfaces []*Interface) []*Interface {
 	getMACAddr(interfaces)
 	activeInterfaces := make([]*Interface, 0)
 	for _, d := range interfaces {
@@ -35,7 +24,7 @@ func GetActiveInterfaces() ([]*Interface, error) {
 			activeInterfaces = append(activeInterfaces, d)
 		}
 	}
-	return activeInterfaces, nil
+	return activeInterfaces
 }
 
 func getMACAddr(interfaces []*Interface) {
@@ -0,0 +1,25 @@
+//go:build !cgo && !windows
+// +build !cgo,!windows
+
+package pppoe
+
+import (
+	"net"
+)
+
+// GetActiveInterfaces lists the interfaces known to the kernel when the
+// binary is built without libpcap.
+func GetActiveInterfaces() ([]*Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make([]*Interface, 0)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		interfaces = append(interfaces, &Interface{Name: iface.Name})
+	}
+	return activeInterfaces(interfaces), nil
+}
@@ -0,0 +1,24 @@
+//go:build cgo || windows
+// +build cgo windows
+
+package pppoe
+
+import (
+	"github.com/google/gopacket/pcap"
+)
+
+func GetActiveInterfaces() ([]*Interface, error) {
+	// Find all devices
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, err
+	}
+	interfaces := make([]*Interface, 0)
+	for _, d := range devices {
+		interfaces = append(interfaces, &Interface{
+			Name:        d.Name,
+			Description: d.Description,
+		})
+	}
+	return activeInterfaces(interfaces), nil
+}
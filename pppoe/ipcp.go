@@ -0,0 +1,272 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"net"
+	"sync"
+)
+
+type PPPIPCPOptionType byte
+
+const (
+	PPPIPCPOptionTypeIPAddresses   PPPIPCPOptionType = 0x1
+	PPPIPCPOptionTypeIPCompression PPPIPCPOptionType = 0x2
+	PPPIPCPOptionTypeIPAddress     PPPIPCPOptionType = 0x3
+	PPPIPCPOptionTypePrimaryDNS    PPPIPCPOptionType = 0x81
+	PPPIPCPOptionTypePrimaryNBNS   PPPIPCPOptionType = 0x82
+	PPPIPCPOptionTypeSecondaryDNS  PPPIPCPOptionType = 0x83
+	PPPIPCPOptionTypeSecondaryNBNS PPPIPCPOptionType = 0x84
+)
+
+type PPPIPCPOption struct {
+	Type   PPPIPCPOptionType
+	Length byte
+	Data   []byte
+}
+
+func (m *PPPIPCPOption) Len() int {
+	return 2 + len(m.Data)
+}
+
+func (m *PPPIPCPOption) Content() []byte {
+	content := make([]byte, 0)
+	content = append(content, byte(m.Type))
+	content = append(content, m.Length)
+	content = append(content, m.Data...)
+	return content
+}
+
+func newIPCPAddressOption(optionType PPPIPCPOptionType, ip net.IP) *PPPIPCPOption {
+	return &PPPIPCPOption{Type: optionType, Length: 6, Data: []byte(ip.To4())}
+}
+
+func FindIPCPOption(options []Option, optionType PPPIPCPOptionType) *PPPIPCPOption {
+	for _, op := range options {
+		if ipcpOp, ok := op.(*PPPIPCPOption); ok && ipcpOp.Type == optionType {
+			return ipcpOp
+		}
+	}
+	return nil
+}
+
+func DecodePPPIPCPOptions(data []byte) []Option {
+	options := make([]Option, 0)
+	for _, op := range DecodePPPLCPOptions(data) {
+		lcpOp := op.(*PPPLCPOption)
+		options = append(options, &PPPIPCPOption{Type: PPPIPCPOptionType(lcpOp.Type), Length: lcpOp.Length, Data: lcpOp.Data})
+	}
+	return options
+}
+
+// PPPIPCP uses the packet format of LCP (RFC 1332 section 2) with its own
+// set of configuration options.
+type PPPIPCP struct {
+	PPPLCP
+}
+
+var LayerTypePPPIPCP = gopacket.RegisterLayerType(
+	2005,
+	gopacket.LayerTypeMetadata{
+		Name:    "LayerTypePPPIPCP",
+		Decoder: gopacket.DecodeFunc(nil),
+	},
+)
+
+func (m *PPPIPCP) LayerType() gopacket.LayerType {
+	return LayerTypePPPIPCP
+}
+
+func (m *PPPIPCP) DecodeFromBytes(data []byte) {
+	m.PPPLCP.DecodeFromBytes(data)
+	switch m.Code {
+	case PPPLCPCodeConfigurationRequest, PPPLCPCodeConfigurationAck, PPPLCPCodeConfigurationNak, PPPLCPCodeConfigurationReject:
+		m.Options = DecodePPPIPCPOptions(data[4:m.Length])
+	}
+}
+
+// AddressPool hands out IPv4 addresses from an inclusive range. It may be
+// shared by several servers.
+type AddressPool struct {
+	mu    sync.Mutex
+	start uint32
+	end   uint32
+	next  uint32
+	used  map[uint32]bool
+}
+
+// NewAddressPool returns a pool of the IPv4 addresses from start to end.
+func NewAddressPool(start net.IP, end net.IP) (*AddressPool, error) {
+	start4, end4 := start.To4(), end.To4()
+	if start4 == nil || end4 == nil {
+		return nil, errors.New("pppoe: address pool bounds must be IPv4 addresses")
+	}
+	if binary.BigEndian.Uint32(start4) > binary.BigEndian.Uint32(end4) {
+		return nil, errors.New("pppoe: address pool starts after its end")
+	}
+	return newAddressPool(binary.BigEndian.Uint32(start4), binary.BigEndian.Uint32(end4)), nil
+}
+
+func newAddressPool(start uint32, end uint32) *AddressPool {
+	return &AddressPool{start: start, end: end, next: start, used: make(map[uint32]bool)}
+}
+
+// Allocate returns a free address, or nil if the pool is exhausted.
+func (p *AddressPool) Allocate() net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for n := uint64(0); n <= uint64(p.end-p.start); n++ {
+		addr := p.next
+		if p.next == p.end {
+			p.next = p.start
+		} else {
+			p.next++
+		}
+		if !p.used[addr] {
+			p.used[addr] = true
+			return net.IP(UInt32ToBytes(addr))
+		}
+	}
+	return nil
+}
+
+func (p *AddressPool) Release(ip net.IP) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ip4 := ip.To4(); ip4 != nil {
+		delete(p.used, binary.BigEndian.Uint32(ip4))
+	}
+}
+
+type IPCPConfig struct {
+	LocalAddress  net.IP
+	Pool          *AddressPool
+	PrimaryDNS    net.IP
+	SecondaryDNS  net.IP
+	PrimaryNBNS   net.IP
+	SecondaryNBNS net.IP
+}
+
+// NewDefaultIPCPConfig returns the settings used by a Server without IPCP
+// configuration, with an address pool of its own.
+func NewDefaultIPCPConfig() *IPCPConfig {
+	return &IPCPConfig{
+		LocalAddress: net.IPv4(10, 64, 0, 1),
+		Pool:         newAddressPool(0x0a400002, 0x0a4000fe), // 10.64.0.2 - 10.64.0.254
+		PrimaryDNS:   net.IPv4(10, 64, 0, 1),
+		SecondaryDNS: net.IPv4(10, 64, 0, 1),
+	}
+}
+
+// ipcpHandler negotiates the IPv4 parameters of the peer, assigning its
+// address and name servers from the configuration.
+type ipcpHandler struct {
+	config      *IPCPConfig
+	peerAddress net.IP
+	// framedAddress is assigned to the peer instead of an address of the
+	// pool if set.
+	framedAddress net.IP
+	rejected      map[PPPIPCPOptionType]bool
+
+	onUp       func()
+	onFinished func()
+}
+
+func newIPCPHandler(config *IPCPConfig) *ipcpHandler {
+	return &ipcpHandler{
+		config:   config,
+		rejected: make(map[PPPIPCPOptionType]bool),
+	}
+}
+
+func (h *ipcpHandler) requestOptions() []Option {
+	options := make([]Option, 0)
+	if !h.rejected[PPPIPCPOptionTypeIPAddress] {
+		options = append(options, newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, h.config.LocalAddress))
+	}
+	return options
+}
+
+func (h *ipcpHandler) configuredAddress(optionType PPPIPCPOptionType) net.IP {
+	switch optionType {
+	case PPPIPCPOptionTypeIPAddress:
+		if h.peerAddress == nil && h.framedAddress != nil {
+			h.peerAddress = h.framedAddress
+		} else if h.peerAddress == nil && h.config.Pool != nil {
+			h.peerAddress = h.config.Pool.Allocate()
+		}
+		return h.peerAddress
+	case PPPIPCPOptionTypePrimaryDNS:
+		return h.config.PrimaryDNS
+	case PPPIPCPOptionTypeSecondaryDNS:
+		return h.config.SecondaryDNS
+	case PPPIPCPOptionTypePrimaryNBNS:
+		return h.config.PrimaryNBNS
+	case PPPIPCPOptionTypeSecondaryNBNS:
+		return h.config.SecondaryNBNS
+	}
+	return nil
+}
+
+func (h *ipcpHandler) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	naks := make([]Option, 0)
+	rejects := make([]Option, 0)
+	for _, op := range options {
+		ipcpOp, ok := op.(*PPPIPCPOption)
+		if !ok {
+			continue
+		}
+		addr := h.configuredAddress(ipcpOp.Type)
+		if addr == nil || len(ipcpOp.Data) != 4 {
+			rejects = append(rejects, op)
+		} else if !net.IP(ipcpOp.Data).Equal(addr) {
+			naks = append(naks, newIPCPAddressOption(ipcpOp.Type, addr))
+		}
+	}
+	if len(rejects) > 0 {
+		return PPPLCPCodeConfigurationReject, rejects
+	}
+	if len(naks) > 0 {
+		return PPPLCPCodeConfigurationNak, naks
+	}
+	return PPPLCPCodeConfigurationAck, options
+}
+
+func (h *ipcpHandler) nakReceived(options []Option) bool {
+	// The local address is not negotiable, keep requesting it.
+	return true
+}
+
+func (h *ipcpHandler) rejectReceived(options []Option) bool {
+	for _, op := range options {
+		if ipcpOp, ok := op.(*PPPIPCPOption); ok {
+			h.rejected[ipcpOp.Type] = true
+		}
+	}
+	return true
+}
+
+func (h *ipcpHandler) layerUp() {
+	if h.onUp != nil {
+		h.onUp()
+	}
+}
+
+func (h *ipcpHandler) layerDown() {
+}
+
+func (h *ipcpHandler) layerFinished() {
+	h.release()
+	if h.onFinished != nil {
+		h.onFinished()
+	}
+}
+
+// release returns the address assigned to the peer to the pool.
+func (h *ipcpHandler) release() {
+	if h.peerAddress != nil && h.framedAddress == nil && h.config.Pool != nil {
+		h.config.Pool.Release(h.peerAddress)
+	}
+	h.peerAddress = nil
+}
@@ -0,0 +1,201 @@
+package pppoe
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestAddressPool(t *testing.T) {
+	tests := []struct {
+		name  string
+		start net.IP
+		end   net.IP
+		// allocations is the number of addresses requested. release is the
+		// index of an allocation given back before the last one, -1 for none.
+		allocations int
+		release     int
+		want        []net.IP
+	}{
+		{
+			name:        "sequential",
+			start:       net.IPv4(10, 0, 0, 1),
+			end:         net.IPv4(10, 0, 0, 3),
+			allocations: 3,
+			release:     -1,
+			want:        []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 3)},
+		},
+		{
+			name:        "exhausted",
+			start:       net.IPv4(10, 0, 0, 1),
+			end:         net.IPv4(10, 0, 0, 2),
+			allocations: 3,
+			release:     -1,
+			want:        []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), nil},
+		},
+		{
+			name:        "released",
+			start:       net.IPv4(10, 0, 0, 1),
+			end:         net.IPv4(10, 0, 0, 2),
+			allocations: 3,
+			release:     0,
+			want:        []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 1)},
+		},
+		{
+			name:        "single address",
+			start:       net.IPv4(192, 168, 1, 1),
+			end:         net.IPv4(192, 168, 1, 1),
+			allocations: 2,
+			release:     -1,
+			want:        []net.IP{net.IPv4(192, 168, 1, 1), nil},
+		},
+		{
+			name:        "across an octet",
+			start:       net.IPv4(10, 0, 0, 255),
+			end:         net.IPv4(10, 0, 1, 0),
+			allocations: 2,
+			release:     -1,
+			want:        []net.IP{net.IPv4(10, 0, 0, 255), net.IPv4(10, 0, 1, 0)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pool, err := NewAddressPool(test.start, test.end)
+			if err != nil {
+				t.Fatalf("NewAddressPool: %v", err)
+			}
+			got := make([]net.IP, 0)
+			for i := 0; i < test.allocations; i++ {
+				if i == test.allocations-1 && test.release >= 0 {
+					pool.Release(got[test.release])
+				}
+				got = append(got, pool.Allocate())
+			}
+			for i := range test.want {
+				if !got[i].Equal(test.want[i]) {
+					t.Errorf("allocation %d = %v, want %v", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestNewAddressPoolInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		start net.IP
+		end   net.IP
+	}{
+		{"start after end", net.IPv4(10, 0, 0, 3), net.IPv4(10, 0, 0, 1)},
+		{"IPv6", net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")},
+		{"missing start", nil, net.IPv4(10, 0, 0, 1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewAddressPool(test.start, test.end); err == nil {
+				t.Error("NewAddressPool succeeded")
+			}
+		})
+	}
+}
+
+func TestIPCPCheckRequest(t *testing.T) {
+	config := &IPCPConfig{
+		LocalAddress: net.IPv4(10, 0, 0, 1),
+		PrimaryDNS:   net.IPv4(10, 0, 0, 53),
+	}
+	peerAddress := net.IPv4(10, 0, 0, 2)
+	tests := []struct {
+		name      string
+		options   []Option
+		wantCode  PPPLCPCode
+		wantReply []Option
+	}{
+		{
+			name:      "assigned address",
+			options:   []Option{newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, peerAddress)},
+			wantCode:  PPPLCPCodeConfigurationAck,
+			wantReply: []Option{newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, peerAddress)},
+		},
+		{
+			name: "address and name server requested",
+			options: []Option{
+				newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, net.IPv4zero),
+				newIPCPAddressOption(PPPIPCPOptionTypePrimaryDNS, net.IPv4zero),
+			},
+			wantCode: PPPLCPCodeConfigurationNak,
+			wantReply: []Option{
+				newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, peerAddress),
+				newIPCPAddressOption(PPPIPCPOptionTypePrimaryDNS, config.PrimaryDNS),
+			},
+		},
+		{
+			name: "unconfigured name server",
+			options: []Option{
+				newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, net.IPv4zero),
+				newIPCPAddressOption(PPPIPCPOptionTypeSecondaryDNS, net.IPv4zero),
+			},
+			wantCode:  PPPLCPCodeConfigurationReject,
+			wantReply: []Option{newIPCPAddressOption(PPPIPCPOptionTypeSecondaryDNS, net.IPv4zero)},
+		},
+		{
+			name:      "short address",
+			options:   []Option{&PPPIPCPOption{Type: PPPIPCPOptionTypeIPAddress, Length: 4, Data: []byte{10, 0}}},
+			wantCode:  PPPLCPCodeConfigurationReject,
+			wantReply: []Option{&PPPIPCPOption{Type: PPPIPCPOptionTypeIPAddress, Length: 4, Data: []byte{10, 0}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := *config
+			pool, err := NewAddressPool(peerAddress, peerAddress)
+			if err != nil {
+				t.Fatalf("NewAddressPool: %v", err)
+			}
+			config.Pool = pool
+			code, reply := newIPCPHandler(&config).checkRequest(test.options)
+			if code != test.wantCode {
+				t.Errorf("code = %s, want %s", code, test.wantCode)
+			}
+			if !reflect.DeepEqual(reply, test.wantReply) {
+				t.Errorf("reply = %v, want %v", reply, test.wantReply)
+			}
+		})
+	}
+}
+
+func TestDecodePPPIPCPOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []Option
+	}{
+		{
+			name: "address",
+			data: []byte{3, 6, 10, 0, 0, 1},
+			want: []Option{newIPCPAddressOption(PPPIPCPOptionTypeIPAddress, net.IPv4(10, 0, 0, 1))},
+		},
+		{
+			name: "truncated",
+			data: []byte{3, 6, 10, 0},
+			want: []Option{},
+		},
+		{
+			name: "zero length",
+			data: []byte{3, 0, 10, 0, 0, 1},
+			want: []Option{},
+		},
+		{
+			name: "empty",
+			data: []byte{},
+			want: []Option{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DecodePPPIPCPOptions(test.data); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DecodePPPIPCPOptions(%x) = %v, want %v", test.data, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package pppoe
+
+import (
+	"bytes"
+	"github.com/google/gopacket"
+	"net"
+)
+
+type PPPIPV6CPOptionType byte
+
+const (
+	PPPIPV6CPOptionTypeInterfaceIdentifier PPPIPV6CPOptionType = 0x1
+	PPPIPV6CPOptionTypeCompression         PPPIPV6CPOptionType = 0x2
+)
+
+type PPPIPV6CPOption struct {
+	Type   PPPIPV6CPOptionType
+	Length byte
+	Data   []byte
+}
+
+func (m *PPPIPV6CPOption) Len() int {
+	return 2 + len(m.Data)
+}
+
+func (m *PPPIPV6CPOption) Content() []byte {
+	content := make([]byte, 0)
+	content = append(content, byte(m.Type))
+	content = append(content, m.Length)
+	content = append(content, m.Data...)
+	return content
+}
+
+func FindIPV6CPOption(options []Option, optionType PPPIPV6CPOptionType) *PPPIPV6CPOption {
+	for _, op := range options {
+		if ipv6cpOp, ok := op.(*PPPIPV6CPOption); ok && ipv6cpOp.Type == optionType {
+			return ipv6cpOp
+		}
+	}
+	return nil
+}
+
+func DecodePPPIPV6CPOptions(data []byte) []Option {
+	options := make([]Option, 0)
+	for _, op := range DecodePPPLCPOptions(data) {
+		lcpOp := op.(*PPPLCPOption)
+		options = append(options, &PPPIPV6CPOption{Type: PPPIPV6CPOptionType(lcpOp.Type), Length: lcpOp.Length, Data: lcpOp.Data})
+	}
+	return options
+}
+
+// PPPIPV6CP uses the packet format of LCP (RFC 5072 section 3) with its own
+// set of configuration options.
+type PPPIPV6CP struct {
+	PPPLCP
+}
+
+var LayerTypePPPIPV6CP = gopacket.RegisterLayerType(
+	2006,
+	gopacket.LayerTypeMetadata{
+		Name:    "LayerTypePPPIPV6CP",
+		Decoder: gopacket.DecodeFunc(nil),
+	},
+)
+
+func (m *PPPIPV6CP) LayerType() gopacket.LayerType {
+	return LayerTypePPPIPV6CP
+}
+
+func (m *PPPIPV6CP) DecodeFromBytes(data []byte) {
+	m.PPPLCP.DecodeFromBytes(data)
+	switch m.Code {
+	case PPPLCPCodeConfigurationRequest, PPPLCPCodeConfigurationAck, PPPLCPCodeConfigurationNak, PPPLCPCodeConfigurationReject:
+		m.Options = DecodePPPIPV6CPOptions(data[4:m.Length])
+	}
+}
+
+var zeroInterfaceIdentifier = make([]byte, 8)
+
+// generateInterfaceIdentifier returns a random non-zero identifier that
+// differs from avoid.
+func generateInterfaceIdentifier(avoid []byte) []byte {
+	for {
+		id := GenerateRandomBytes(8)
+		if !bytes.Equal(id, zeroInterfaceIdentifier) && !bytes.Equal(id, avoid) {
+			return id
+		}
+	}
+}
+
+// LinkLocalAddress returns the fe80::/64 address formed from an interface
+// identifier.
+func LinkLocalAddress(interfaceIdentifier []byte) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfe
+	ip[1] = 0x80
+	copy(ip[8:], interfaceIdentifier)
+	return ip
+}
+
+// ipv6cpHandler negotiates the interface identifiers of both ends of the
+// link, resolving collisions as described in RFC 5072 section 4.1.
+type ipv6cpHandler struct {
+	interfaceIdentifier     []byte
+	peerInterfaceIdentifier []byte
+	rejected                bool
+
+	onUp func()
+}
+
+func newIPV6CPHandler() *ipv6cpHandler {
+	return &ipv6cpHandler{
+		interfaceIdentifier: generateInterfaceIdentifier(nil),
+	}
+}
+
+func (h *ipv6cpHandler) requestOptions() []Option {
+	if h.rejected {
+		return []Option{}
+	}
+	return []Option{&PPPIPV6CPOption{Type: PPPIPV6CPOptionTypeInterfaceIdentifier, Length: 10, Data: h.interfaceIdentifier}}
+}
+
+func (h *ipv6cpHandler) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	naks := make([]Option, 0)
+	rejects := make([]Option, 0)
+	for _, op := range options {
+		ipv6cpOp, ok := op.(*PPPIPV6CPOption)
+		if !ok {
+			continue
+		}
+		switch {
+		case ipv6cpOp.Type != PPPIPV6CPOptionTypeInterfaceIdentifier || len(ipv6cpOp.Data) != 8:
+			rejects = append(rejects, op)
+		case bytes.Equal(ipv6cpOp.Data, zeroInterfaceIdentifier) || bytes.Equal(ipv6cpOp.Data, h.interfaceIdentifier):
+			naks = append(naks, &PPPIPV6CPOption{Type: PPPIPV6CPOptionTypeInterfaceIdentifier, Length: 10, Data: generateInterfaceIdentifier(h.interfaceIdentifier)})
+		}
+	}
+	if len(rejects) > 0 {
+		return PPPLCPCodeConfigurationReject, rejects
+	}
+	if len(naks) > 0 {
+		return PPPLCPCodeConfigurationNak, naks
+	}
+	if idOption := FindIPV6CPOption(options, PPPIPV6CPOptionTypeInterfaceIdentifier); idOption != nil {
+		h.peerInterfaceIdentifier = idOption.Data
+	}
+	return PPPLCPCodeConfigurationAck, options
+}
+
+func (h *ipv6cpHandler) nakReceived(options []Option) bool {
+	if idOption := FindIPV6CPOption(options, PPPIPV6CPOptionTypeInterfaceIdentifier); idOption != nil {
+		if len(idOption.Data) == 8 && !bytes.Equal(idOption.Data, zeroInterfaceIdentifier) && !bytes.Equal(idOption.Data, h.peerInterfaceIdentifier) {
+			h.interfaceIdentifier = idOption.Data
+		} else {
+			h.interfaceIdentifier = generateInterfaceIdentifier(h.peerInterfaceIdentifier)
+		}
+	}
+	return true
+}
+
+func (h *ipv6cpHandler) rejectReceived(options []Option) bool {
+	if FindIPV6CPOption(options, PPPIPV6CPOptionTypeInterfaceIdentifier) != nil {
+		h.rejected = true
+	}
+	return true
+}
+
+func (h *ipv6cpHandler) layerUp() {
+	if h.onUp != nil {
+		h.onUp()
+	}
+}
+
+func (h *ipv6cpHandler) layerDown() {
+}
+
+func (h *ipv6cpHandler) layerFinished() {
+}
@@ -0,0 +1,95 @@
+package pppoe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func newInterfaceIdentifierOption(id []byte) *PPPIPV6CPOption {
+	return &PPPIPV6CPOption{Type: PPPIPV6CPOptionTypeInterfaceIdentifier, Length: byte(2 + len(id)), Data: id}
+}
+
+func TestIPV6CPCheckRequest(t *testing.T) {
+	local := []byte{1, 1, 1, 1, 1, 1, 1, 1}
+	peer := []byte{2, 2, 2, 2, 2, 2, 2, 2}
+	tests := []struct {
+		name     string
+		options  []Option
+		wantCode PPPLCPCode
+		// wantPeer is the identifier recorded for the peer once acked.
+		wantPeer []byte
+	}{
+		{"distinct identifier", []Option{newInterfaceIdentifierOption(peer)}, PPPLCPCodeConfigurationAck, peer},
+		{"zero identifier", []Option{newInterfaceIdentifierOption(zeroInterfaceIdentifier)}, PPPLCPCodeConfigurationNak, nil},
+		{"local identifier", []Option{newInterfaceIdentifierOption(local)}, PPPLCPCodeConfigurationNak, nil},
+		{"short identifier", []Option{newInterfaceIdentifierOption([]byte{2, 2, 2, 2})}, PPPLCPCodeConfigurationReject, nil},
+		{"unknown option", []Option{&PPPIPV6CPOption{Type: 2, Length: 4, Data: []byte{0, 0}}}, PPPLCPCodeConfigurationReject, nil},
+		{"no option", []Option{}, PPPLCPCodeConfigurationAck, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newIPV6CPHandler()
+			h.interfaceIdentifier = local
+			code, reply := h.checkRequest(test.options)
+			if code != test.wantCode {
+				t.Fatalf("code = %s, want %s", code, test.wantCode)
+			}
+			if !bytes.Equal(h.peerInterfaceIdentifier, test.wantPeer) {
+				t.Errorf("peer identifier = %x, want %x", h.peerInterfaceIdentifier, test.wantPeer)
+			}
+			if code != PPPLCPCodeConfigurationNak {
+				return
+			}
+			suggested := FindIPV6CPOption(reply, PPPIPV6CPOptionTypeInterfaceIdentifier)
+			if suggested == nil || len(suggested.Data) != 8 || bytes.Equal(suggested.Data, zeroInterfaceIdentifier) || bytes.Equal(suggested.Data, local) {
+				t.Errorf("Configure-Nak suggests %v, want a new non-zero identifier", reply)
+			}
+		})
+	}
+}
+
+func TestIPV6CPNakReceived(t *testing.T) {
+	local := []byte{1, 1, 1, 1, 1, 1, 1, 1}
+	peer := []byte{2, 2, 2, 2, 2, 2, 2, 2}
+	suggested := []byte{3, 3, 3, 3, 3, 3, 3, 3}
+	tests := []struct {
+		name      string
+		suggested []byte
+		// wantSuggested tells whether the suggested identifier is adopted
+		// rather than a random one.
+		wantSuggested bool
+	}{
+		{"usable suggestion", suggested, true},
+		{"zero suggestion", zeroInterfaceIdentifier, false},
+		{"peer identifier suggested", peer, false},
+		{"short suggestion", []byte{3, 3}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := newIPV6CPHandler()
+			h.interfaceIdentifier = local
+			h.peerInterfaceIdentifier = peer
+			if !h.nakReceived([]Option{newInterfaceIdentifierOption(test.suggested)}) {
+				t.Fatal("nakReceived gave up")
+			}
+			got := h.interfaceIdentifier
+			if test.wantSuggested {
+				if !bytes.Equal(got, test.suggested) {
+					t.Errorf("identifier = %x, want %x", got, test.suggested)
+				}
+				return
+			}
+			if len(got) != 8 || bytes.Equal(got, zeroInterfaceIdentifier) || bytes.Equal(got, peer) {
+				t.Errorf("identifier = %x, want a random one distinct from the peer", got)
+			}
+		})
+	}
+}
+
+func TestLinkLocalAddress(t *testing.T) {
+	got := LinkLocalAddress([]byte{0x02, 0x11, 0x22, 0xff, 0xfe, 0x33, 0x44, 0x55})
+	if want := net.ParseIP("fe80::211:22ff:fe33:4455"); !got.Equal(want) {
+		t.Errorf("LinkLocalAddress = %v, want %v", got, want)
+	}
+}
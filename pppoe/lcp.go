@@ -2,6 +2,7 @@ package pppoe
 
 import (
 	"encoding/binary"
+	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"net"
@@ -25,6 +26,29 @@ const (
 	PPPLCPCodeTimeRemaining        PPPLCPCode = 0xd
 )
 
+var pppLCPCodeNames = map[PPPLCPCode]string{
+	PPPLCPCodeConfigurationRequest: "Configuration Request",
+	PPPLCPCodeConfigurationAck:     "Configuration Ack",
+	PPPLCPCodeConfigurationNak:     "Configuration Nak",
+	PPPLCPCodeConfigurationReject:  "Configuration Reject",
+	PPPLCPCodeTerminateRequest:     "Termination Request",
+	PPPLCPCodeTerminateAck:         "Termination Ack",
+	PPPLCPCodeCodeReject:           "Code Reject",
+	PPPLCPCodeProtocolReject:       "Protocol Reject",
+	PPPLCPCodeEchoRequest:          "Echo Request",
+	PPPLCPCodeEchoReply:            "Echo Reply",
+	PPPLCPCodeDiscardRequest:       "Discard Request",
+	PPPLCPCodeIdentification:       "Identification",
+	PPPLCPCodeTimeRemaining:        "Time Remaining",
+}
+
+func (c PPPLCPCode) String() string {
+	if name, ok := pppLCPCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown Code %d", c)
+}
+
 type PPPLCP struct {
 	Code       PPPLCPCode
 	Identifier byte
@@ -36,8 +60,8 @@ type PPPLCP struct {
 var LayerTypePPPLCP = gopacket.RegisterLayerType(
 	2001,
 	gopacket.LayerTypeMetadata{
-		"LayerTypePPPLCP",
-		gopacket.DecodeFunc(nil),
+		Name:    "LayerTypePPPLCP",
+		Decoder: gopacket.DecodeFunc(nil),
 	},
 )
 
@@ -142,6 +166,18 @@ func (m *PPPLCPTerminateOption) Content() []byte {
 	return m.Data
 }
 
+type PPPLCPRejectOption struct {
+	Data []byte
+}
+
+func (m *PPPLCPRejectOption) Len() int {
+	return len(m.Data)
+}
+
+func (m *PPPLCPRejectOption) Content() []byte {
+	return m.Data
+}
+
 func FindLCPOption(options []Option, optionType PPPLCPOptionType) *PPPLCPOption {
 	for _, op := range options {
 		if pppOp, ok := op.(*PPPLCPOption); ok && pppOp.Type == optionType {
@@ -153,8 +189,12 @@ func FindLCPOption(options []Option, optionType PPPLCPOptionType) *PPPLCPOption
 
 func DecodePPPLCPOptions(data []byte) []Option {
 	options := make([]Option, 0)
-	for i := 0; i < len(data); {
-		op := PPPLCPOption{PPPLCPOptionType(data[i]), data[i+1], data[(i + 2):(i + 2 + int(data[i+1]) - 2)]}
+	for i := 0; i+2 <= len(data); {
+		length := int(data[i+1])
+		if length < 2 || i+length > len(data) {
+			break
+		}
+		op := PPPLCPOption{PPPLCPOptionType(data[i]), data[i+1], data[(i + 2):(i + length)]}
 		options = append(options, &op)
 		i += op.Len()
 	}
@@ -162,29 +202,38 @@ func DecodePPPLCPOptions(data []byte) []Option {
 }
 
 func DecodeEchoLCPOptions(data []byte) []Option {
-	rest := make([]byte, 0)
-	if len(data) > 4 {
-		rest = data[4:]
+	if len(data) < 4 {
+		return []Option{&PPPLCPEchoOption{Data: make([]byte, 0)}}
 	}
-	return []Option{&PPPLCPEchoOption{Magic: binary.BigEndian.Uint32(data[:4]), Data: rest}}
+	return []Option{&PPPLCPEchoOption{Magic: binary.BigEndian.Uint32(data[:4]), Data: data[4:]}}
 }
 
 func DecodeTerminateLCPOptions(data []byte) []Option {
 	return []Option{&PPPLCPTerminateOption{Data: data}}
 }
 
+func DecodeRejectLCPOptions(data []byte) []Option {
+	return []Option{&PPPLCPRejectOption{Data: data}}
+}
+
 func (m *PPPLCP) DecodeFromBytes(data []byte) {
 	m.Code = PPPLCPCode(data[0])
 	m.Identifier = data[1]
 	m.Length = binary.BigEndian.Uint16(data[2:4])
+	if int(m.Length) > len(data) || m.Length < 4 {
+		m.Length = uint16(len(data))
+	}
 	switch m.Code {
-	case PPPLCPCodeEchoRequest:
-	case PPPLCPCodeEchoReply:
+	case PPPLCPCodeEchoRequest, PPPLCPCodeEchoReply, PPPLCPCodeDiscardRequest:
 		m.Options = DecodeEchoLCPOptions(data[4:m.Length])
-	case PPPLCPCodeTerminateRequest:
+	case PPPLCPCodeTerminateRequest, PPPLCPCodeTerminateAck:
 		m.Options = DecodeTerminateLCPOptions(data[4:m.Length])
-	default:
+	case PPPLCPCodeCodeReject, PPPLCPCodeProtocolReject:
+		m.Options = DecodeRejectLCPOptions(data[4:m.Length])
+	case PPPLCPCodeConfigurationRequest, PPPLCPCodeConfigurationAck, PPPLCPCodeConfigurationNak, PPPLCPCodeConfigurationReject:
 		m.Options = DecodePPPLCPOptions(data[4:m.Length])
+	default:
+		m.Options = DecodeRejectLCPOptions(data[4:m.Length])
 	}
 
 	optionsLen := 0
@@ -199,10 +248,19 @@ func (m *PPPLCP) DecodeFromBytes(data []byte) {
 	}
 }
 
-func sendLCP(dst net.HardwareAddr, code PPPLCPCode, sid uint16, id byte, options []Option) {
-	pppLayer := layers.PPP{}
-	pppLayer.PPPType = PPPTypeLCP
+func (s *Server) sendLCP(dst net.HardwareAddr, code PPPLCPCode, sid uint16, id byte, options []Option) {
+	s.sendControlProtocol(dst, PPPTypeLCP, code, sid, id, options)
+}
 
+// sendControlProtocol sends a packet in the format shared by LCP and the
+// NCPs (RFC 1661 section 5).
+func (s *Server) sendControlProtocol(dst net.HardwareAddr, protocol layers.PPPType, code PPPLCPCode, sid uint16, id byte, options []Option) {
+	payload := encodeControlProtocol(protocol, code, id, options)
+	s.sendPacket(dst, payload, layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(payload)))
+}
+
+// encodeControlProtocol serializes a PPP packet of LCP or one of the NCPs.
+func encodeControlProtocol(protocol layers.PPPType, code PPPLCPCode, id byte, options []Option) []byte {
 	optionsLen := 0
 	for _, op := range options {
 		optionsLen += op.Len()
@@ -212,7 +270,7 @@ func sendLCP(dst net.HardwareAddr, code PPPLCPCode, sid uint16, id byte, options
 	so := gopacket.SerializeOptions{}
 	gopacket.SerializeLayers(buffer, so,
 		&layers.PPP{
-			PPPType: PPPTypeLCP,
+			PPPType: protocol,
 		},
 		&PPPLCP{
 			Code:       code,
@@ -221,5 +279,181 @@ func sendLCP(dst net.HardwareAddr, code PPPLCPCode, sid uint16, id byte, options
 			Options:    options,
 		},
 	)
-	sendPacket(dst, buffer.Bytes(), layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(buffer.Bytes())))
+	return buffer.Bytes()
+}
+
+const (
+	DefaultMRU = 1492
+	MinMRU     = 64
+)
+
+// lcpHandler negotiates the link options of the access concentrator side.
+type lcpHandler struct {
+	server         *Server
+	peer           net.HardwareAddr
+	sid            uint16
+	maxMRU         uint16
+	mru            uint16
+	authProtocol   AuthProtocol
+	authPreference []AuthProtocol
+	authTried      map[AuthProtocol]bool
+	magic          uint32
+	peerMRU        uint16
+	peerMagic      uint32
+	rejected       map[PPPLCPOptionType]bool
+
+	onUp       func()
+	onDown     func()
+	onFinished func()
+}
+
+func newLCPHandler(server *Server, peer net.HardwareAddr, sid uint16, authPreference []AuthProtocol) *lcpHandler {
+	return &lcpHandler{
+		server:         server,
+		peer:           peer,
+		sid:            sid,
+		maxMRU:         server.config.MRU,
+		mru:            server.config.MRU,
+		authProtocol:   authPreference[0],
+		authPreference: authPreference,
+		authTried:      map[AuthProtocol]bool{authPreference[0]: true},
+		magic:          binary.BigEndian.Uint32(GenerateRandomBytes(4)),
+		peerMRU:        server.config.MRU,
+		rejected:       make(map[PPPLCPOptionType]bool),
+	}
+}
+
+func (h *lcpHandler) requestOptions() []Option {
+	options := make([]Option, 0)
+	if !h.rejected[PPPLCPOptionTypeMRU] {
+		options = append(options, &PPPLCPOption{Type: PPPLCPOptionTypeMRU, Length: 4, Data: UInt16ToBytes(h.mru)})
+	}
+	authData := h.authProtocol.Data()
+	options = append(options, &PPPLCPOption{Type: PPPLCPOptionTypeAuthenticationProtocol, Length: byte(2 + len(authData)), Data: authData})
+	if !h.rejected[PPPLCPOptionTypeMagicNumber] {
+		options = append(options, &PPPLCPOption{Type: PPPLCPOptionTypeMagicNumber, Length: 6, Data: UInt32ToBytes(h.magic)})
+	}
+	return options
+}
+
+func (h *lcpHandler) checkRequest(options []Option) (PPPLCPCode, []Option) {
+	naks := make([]Option, 0)
+	rejects := make([]Option, 0)
+	for _, op := range options {
+		lcpOp, ok := op.(*PPPLCPOption)
+		if !ok {
+			continue
+		}
+		switch lcpOp.Type {
+		case PPPLCPOptionTypeMRU:
+			if len(lcpOp.Data) != 2 {
+				rejects = append(rejects, op)
+			} else if mru := binary.BigEndian.Uint16(lcpOp.Data); mru < MinMRU || mru > h.maxMRU {
+				naks = append(naks, &PPPLCPOption{Type: PPPLCPOptionTypeMRU, Length: 4, Data: UInt16ToBytes(h.maxMRU)})
+			}
+		case PPPLCPOptionTypeMagicNumber:
+			if len(lcpOp.Data) != 4 {
+				rejects = append(rejects, op)
+			} else if magic := binary.BigEndian.Uint32(lcpOp.Data); magic == 0 || magic == h.magic {
+				naks = append(naks, &PPPLCPOption{Type: PPPLCPOptionTypeMagicNumber, Length: 6, Data: GenerateRandomBytes(4)})
+			}
+		default:
+			rejects = append(rejects, op)
+		}
+	}
+	if len(rejects) > 0 {
+		return PPPLCPCodeConfigurationReject, rejects
+	}
+	if len(naks) > 0 {
+		return PPPLCPCodeConfigurationNak, naks
+	}
+	h.peerMRU = h.maxMRU
+	if mruOption := FindLCPOption(options, PPPLCPOptionTypeMRU); mruOption != nil {
+		h.peerMRU = binary.BigEndian.Uint16(mruOption.Data)
+	}
+	if magicOption := FindLCPOption(options, PPPLCPOptionTypeMagicNumber); magicOption != nil {
+		h.peerMagic = binary.BigEndian.Uint32(magicOption.Data)
+	}
+	return PPPLCPCodeConfigurationAck, options
+}
+
+// nextAuthProtocol picks the protocol to propose after the peer refused the
+// current one, preferring the peer's suggestion if it is acceptable to us.
+func (h *lcpHandler) nextAuthProtocol(suggested []byte) bool {
+	if auth, ok := ParseAuthProtocol(suggested); ok && !h.authTried[auth] {
+		for _, preferred := range h.authPreference {
+			if preferred == auth {
+				h.authProtocol = auth
+				h.authTried[auth] = true
+				return true
+			}
+		}
+	}
+	for _, preferred := range h.authPreference {
+		if !h.authTried[preferred] {
+			h.authProtocol = preferred
+			h.authTried[preferred] = true
+			return true
+		}
+	}
+	return false
+}
+
+func (h *lcpHandler) nakReceived(options []Option) bool {
+	if mruOption := FindLCPOption(options, PPPLCPOptionTypeMRU); mruOption != nil && len(mruOption.Data) == 2 {
+		if mru := binary.BigEndian.Uint16(mruOption.Data); mru >= MinMRU && mru <= h.maxMRU {
+			h.mru = mru
+		}
+	}
+	if FindLCPOption(options, PPPLCPOptionTypeMagicNumber) != nil {
+		h.magic = binary.BigEndian.Uint32(GenerateRandomBytes(4))
+	}
+	if authOption := FindLCPOption(options, PPPLCPOptionTypeAuthenticationProtocol); authOption != nil {
+		refused := h.authProtocol
+		suggested, _ := ParseAuthProtocol(authOption.Data)
+		h.server.emit(Event{
+			Direction: EventIncoming,
+			Peer:      h.peer,
+			SessionID: h.sid,
+			Protocol:  "PPP LCP",
+			Message:   fmt.Sprintf("Authentication %s refused, %s suggested", refused, suggested),
+			Fields:    map[string]interface{}{"refused": refused.String(), "suggested": suggested.String()},
+		})
+		if !h.nextAuthProtocol(authOption.Data) {
+			h.server.logIncoming(h.peer, h.sid, "PPP LCP", "No acceptable authentication protocol left")
+			return false
+		}
+	}
+	return true
+}
+
+func (h *lcpHandler) rejectReceived(options []Option) bool {
+	for _, op := range options {
+		if lcpOp, ok := op.(*PPPLCPOption); ok {
+			if lcpOp.Type == PPPLCPOptionTypeAuthenticationProtocol {
+				h.server.logIncoming(h.peer, h.sid, "PPP LCP", "Authentication rejected")
+				return false
+			}
+			h.rejected[lcpOp.Type] = true
+		}
+	}
+	return true
+}
+
+func (h *lcpHandler) layerUp() {
+	if h.onUp != nil {
+		h.onUp()
+	}
+}
+
+func (h *lcpHandler) layerDown() {
+	if h.onDown != nil {
+		h.onDown()
+	}
+}
+
+func (h *lcpHandler) layerFinished() {
+	if h.onFinished != nil {
+		h.onFinished()
+	}
 }
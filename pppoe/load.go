@@ -0,0 +1,356 @@
+package pppoe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLoadConcurrency = 100
+	DefaultLoadTimeout     = 30 * time.Second
+)
+
+// LoadPhases name the latency histograms of a LoadReport, the phases of
+// ClientTimings followed by their total.
+var LoadPhases = []string{"discovery", "request", "lcp", "authentication", "ipcp", "total"}
+
+// LatencyBuckets are the upper bounds of the buckets of a LatencyHistogram.
+// A last bucket holds the samples above them.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	200 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2 * time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// LatencyHistogram counts durations in the LatencyBuckets.
+type LatencyHistogram struct {
+	Count   int
+	Sum     time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Buckets []int
+}
+
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Buckets: make([]int, len(LatencyBuckets)+1)}
+}
+
+func (h *LatencyHistogram) Add(d time.Duration) {
+	if h.Count == 0 || d < h.Min {
+		h.Min = d
+	}
+	if d > h.Max {
+		h.Max = d
+	}
+	h.Count++
+	h.Sum += d
+	i := 0
+	for i < len(LatencyBuckets) && d > LatencyBuckets[i] {
+		i++
+	}
+	h.Buckets[i]++
+}
+
+func (h *LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile of the samples, Max if it is in the last bucket.
+func (h *LatencyHistogram) Percentile(p float64) time.Duration {
+	rank := int(float64(h.Count)*p/100 + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	seen := 0
+	for i, n := range h.Buckets {
+		seen += n
+		if seen >= rank && i < len(LatencyBuckets) {
+			if LatencyBuckets[i] > h.Max {
+				return h.Max
+			}
+			return LatencyBuckets[i]
+		}
+	}
+	return h.Max
+}
+
+// Subscriber is the account of an emulated client.
+type Subscriber struct {
+	Username string
+	Password string
+}
+
+// LoadConfig holds the settings of RunLoad. Zero values are replaced by the
+// package defaults.
+type LoadConfig struct {
+	Interface *Interface
+	// Transport is shared by all clients. If nil, the interface is opened
+	// with Backend. It is closed when RunLoad returns.
+	Transport Transport
+	Backend   string
+
+	// Client is the template of the emulated clients. Its hardware address,
+	// credentials and transport are set by RunLoad, and its events are
+	// discarded unless LogOutput is set.
+	Client ClientConfig
+	// Subscribers are used in turn by the clients. Count is the number of
+	// clients dialed, one per subscriber if zero.
+	Subscribers []Subscriber
+	Count       int
+	// BaseMAC is the address of the first client, the next ones are
+	// incremented from it. It defaults to a random locally administered
+	// address.
+	BaseMAC net.HardwareAddr
+
+	// Rate is the number of clients started per second, unlimited if zero.
+	// At most Concurrency clients are dialing or holding a session at once.
+	Rate        float64
+	Concurrency int
+	// HoldTime is how long each session is kept up before it is closed.
+	// Timeout bounds the establishment of each session.
+	HoldTime time.Duration
+	Timeout  time.Duration
+
+	// OnResult is called once each client has connected or failed, from
+	// the goroutine of the client.
+	OnResult func(*LoadResult)
+}
+
+func (c *LoadConfig) setDefaults() {
+	if c.Count == 0 {
+		c.Count = len(c.Subscribers)
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = DefaultLoadConcurrency
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultLoadTimeout
+	}
+	if c.BaseMAC == nil {
+		c.BaseMAC = append(net.HardwareAddr{0x02}, GenerateRandomBytes(2)...)
+		c.BaseMAC = append(c.BaseMAC, 0, 0, 0)
+	}
+	if c.Client.LogOutput == nil {
+		c.Client.LogOutput = ioutil.Discard
+	}
+}
+
+// LoadResult is the outcome of one emulated client.
+type LoadResult struct {
+	HardwareAddr net.HardwareAddr
+	Username     string
+	Session      *ClientSession
+	Timings      ClientTimings
+	// Err is the reason the client failed, nil if it connected. Stage is
+	// the step it had reached, one of LoadPhases.
+	Err   error
+	Stage string
+}
+
+// Reason describes the failure of the client, empty if it connected.
+func (r *LoadResult) Reason() string {
+	switch r.Err {
+	case nil:
+		return ""
+	case context.DeadlineExceeded:
+		return fmt.Sprintf("timeout in %s", r.Stage)
+	case context.Canceled:
+		return fmt.Sprintf("interrupted in %s", r.Stage)
+	}
+	return r.Err.Error()
+}
+
+// LoadReport summarizes a run of RunLoad.
+type LoadReport struct {
+	Started   int
+	Connected int
+	Failed    int
+	// Dropped counts the sessions terminated by the concentrator before
+	// the end of their hold time.
+	Dropped  int
+	Duration time.Duration
+	// Phases holds the latency histograms keyed by the names of
+	// LoadPhases, of the clients that completed the phase.
+	Phases map[string]*LatencyHistogram
+	// Failures counts the failed clients by Reason.
+	Failures map[string]int
+}
+
+func newLoadReport() *LoadReport {
+	report := &LoadReport{
+		Phases:   make(map[string]*LatencyHistogram),
+		Failures: make(map[string]int),
+	}
+	for _, phase := range LoadPhases {
+		report.Phases[phase] = NewLatencyHistogram()
+	}
+	return report
+}
+
+func (r *LoadReport) add(result *LoadResult) {
+	timings := []time.Duration{
+		result.Timings.Discovery,
+		result.Timings.Request,
+		result.Timings.LCP,
+		result.Timings.Authentication,
+		result.Timings.IPCP,
+	}
+	for i, d := range timings {
+		if d != 0 {
+			r.Phases[LoadPhases[i]].Add(d)
+		}
+	}
+	if result.Err != nil {
+		r.Failed++
+		r.Failures[result.Reason()]++
+		return
+	}
+	r.Connected++
+	r.Phases["total"].Add(result.Timings.Total())
+}
+
+// loadMAC returns the address of the i-th client.
+func loadMAC(base net.HardwareAddr, i int) net.HardwareAddr {
+	mac := append(net.HardwareAddr(nil), base...)
+	n := binary.BigEndian.Uint32(append([]byte{0}, mac[3:6]...)) + uint32(i)
+	mac[3], mac[4], mac[5] = byte(n>>16), byte(n>>8), byte(n)
+	return mac
+}
+
+// RunLoad emulates Count subscribers dialing PPPoE sessions on one
+// interface, each with its own hardware address, and returns their
+// statistics once all of them have ended. Cancelling ctx stops starting new
+// clients and closes the running ones.
+//
+// The interface is opened in promiscuous mode so that the frames sent to
+// the emulated addresses are received.
+func RunLoad(ctx context.Context, config LoadConfig) (*LoadReport, error) {
+	config.setDefaults()
+	if len(config.Subscribers) == 0 {
+		return nil, errors.New("pppoe: no subscribers")
+	}
+	if config.Count < 0 || config.Concurrency < 0 {
+		return nil, errors.New("pppoe: negative client count or concurrency")
+	}
+	if len(config.BaseMAC) != 6 {
+		return nil, fmt.Errorf("pppoe: invalid base address %s", config.BaseMAC)
+	}
+	transport := config.Transport
+	if transport == nil {
+		if config.Interface == nil {
+			return nil, errors.New("pppoe: no interface configured")
+		}
+		var err error
+		transport, err = OpenPromiscuousTransport(config.Backend, config.Interface.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mux := newFrameMux(transport)
+	defer mux.Close()
+
+	report := newLoadReport()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, config.Concurrency)
+	var ticks <-chan time.Time
+	if config.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / config.Rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	start := time.Now()
+loop:
+	for i := 0; i < config.Count; i++ {
+		if ticks != nil && i > 0 {
+			select {
+			case <-ticks:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		subscriber := config.Subscribers[i%len(config.Subscribers)]
+		clientConfig := config.Client
+		clientConfig.Interface = config.Interface
+		clientConfig.HardwareAddr = loadMAC(config.BaseMAC, i)
+		clientConfig.Transport = mux.open(clientConfig.HardwareAddr)
+		clientConfig.Username = subscriber.Username
+		clientConfig.Password = subscriber.Password
+		report.Started++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, dropped := runLoadClient(ctx, clientConfig, &config)
+			mu.Lock()
+			report.add(result)
+			if dropped {
+				report.Dropped++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// runLoadClient dials one session and holds it. It reports whether the
+// concentrator terminated the session during the hold time.
+func runLoadClient(ctx context.Context, clientConfig ClientConfig, config *LoadConfig) (*LoadResult, bool) {
+	client := NewClient(clientConfig)
+	dialCtx, cancel := context.WithTimeout(ctx, config.Timeout)
+	session, err := client.Connect(dialCtx)
+	cancel()
+	result := &LoadResult{
+		HardwareAddr: clientConfig.HardwareAddr,
+		Username:     clientConfig.Username,
+		Session:      session,
+		Timings:      client.Timings(),
+		Err:          err,
+		Stage:        client.stage(),
+	}
+	if config.OnResult != nil {
+		config.OnResult(result)
+	}
+	if err != nil {
+		return result, false
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- client.Wait()
+	}()
+	select {
+	case <-time.After(config.HoldTime):
+	case <-ctx.Done():
+	case <-ended:
+		return result, true
+	}
+	client.Close()
+	return result, false
+}
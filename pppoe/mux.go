@@ -0,0 +1,118 @@
+package pppoe
+
+import (
+	"net"
+	"sync"
+)
+
+// muxQueueSize is the number of received frames queued for each port of a
+// frameMux. Frames arriving at a full queue are dropped, as by a NIC.
+const muxQueueSize = 64
+
+// frameMux shares one transport between clients with distinct hardware
+// addresses. Each received frame is delivered to the port of its destination
+// address, other frames are dropped.
+type frameMux struct {
+	transport Transport
+
+	mu    sync.Mutex
+	ports map[string]*muxPort
+
+	writing sync.Mutex
+	done    chan struct{}
+}
+
+func newFrameMux(transport Transport) *frameMux {
+	m := &frameMux{
+		transport: transport,
+		ports:     make(map[string]*muxPort),
+		done:      make(chan struct{}),
+	}
+	go m.dispatch()
+	return m
+}
+
+func (m *frameMux) dispatch() {
+	defer close(m.done)
+	for {
+		frame, err := m.transport.ReadFrame()
+		if err != nil {
+			return
+		}
+		if len(frame) < 6 {
+			continue
+		}
+		m.mu.Lock()
+		port := m.ports[string(frame[:6])]
+		m.mu.Unlock()
+		if port == nil {
+			continue
+		}
+		select {
+		case port.frames <- frame:
+		default:
+		}
+	}
+}
+
+// open returns the port receiving the frames sent to mac. The port is
+// removed from the mux when it is closed.
+func (m *frameMux) open(mac net.HardwareAddr) Transport {
+	port := &muxPort{
+		mux:    m,
+		key:    string(mac),
+		frames: make(chan []byte, muxQueueSize),
+		closed: make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.ports[port.key] = port
+	m.mu.Unlock()
+	return port
+}
+
+// Close closes the shared transport and waits for the dispatcher to stop.
+func (m *frameMux) Close() error {
+	err := m.transport.Close()
+	<-m.done
+	return err
+}
+
+// muxPort is the Transport of one client of a frameMux.
+type muxPort struct {
+	mux    *frameMux
+	key    string
+	frames chan []byte
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (p *muxPort) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-p.frames:
+		return frame, nil
+	case <-p.closed:
+	case <-p.mux.done:
+	}
+	return nil, ErrTransportClosed
+}
+
+func (p *muxPort) WriteFrame(frame []byte) error {
+	select {
+	case <-p.closed:
+		return ErrTransportClosed
+	default:
+	}
+	p.mux.writing.Lock()
+	defer p.mux.writing.Unlock()
+	return p.mux.transport.WriteFrame(frame)
+}
+
+func (p *muxPort) Close() error {
+	p.once.Do(func() {
+		p.mux.mu.Lock()
+		delete(p.mux.ports, p.key)
+		p.mux.mu.Unlock()
+		close(p.closed)
+	})
+	return nil
+}
@@ -0,0 +1,292 @@
+package pppoe
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"net"
+	"os"
+)
+
+// CapturedSession is a PPPoE session reconstructed from a capture file.
+type CapturedSession struct {
+	ID            uint16
+	Client        net.HardwareAddr
+	AC            net.HardwareAddr
+	ACName        string
+	AuthProtocol  AuthProtocol
+	Authenticated bool
+	Credentials   []*Credential
+}
+
+// capturedState follows the authentication phase of one captured session.
+type capturedState struct {
+	session    *CapturedSession
+	challenges map[byte][]byte
+	identity   string
+}
+
+// captureDecoder passively follows the PPPoE sessions of a capture. Unlike
+// Server it answers nothing and sees the packets of both sides.
+type captureDecoder struct {
+	states  map[sessionKey]*capturedState
+	acNames map[string]string
+	order   []*CapturedSession
+	// onCredential, if set, is called for every credential decoded.
+	onCredential func(session *CapturedSession, credential *Credential)
+}
+
+func newCaptureDecoder() *captureDecoder {
+	return &captureDecoder{
+		states:  make(map[sessionKey]*capturedState),
+		acNames: make(map[string]string),
+	}
+}
+
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+type packetDataReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// ReadCapture lists the PPPoE sessions and credentials found in a pcap or
+// pcapng file.
+func ReadCapture(path string) ([]*CapturedSession, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return DecodeCapture(f)
+}
+
+// DecodeCapture lists the PPPoE sessions and credentials found in a pcap or
+// pcapng stream.
+func DecodeCapture(r io.Reader) ([]*CapturedSession, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	var reader packetDataReader
+	if bytes.Equal(magic, pcapngMagic) {
+		reader, err = pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	} else {
+		reader, err = pcapgo.NewReader(br)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := newCaptureDecoder()
+	for {
+		data, _, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return d.order, err
+		}
+		d.decode(gopacket.NewPacket(data, reader.LinkType(), gopacket.Default))
+	}
+	return d.order, nil
+}
+
+// pairKey identifies a session regardless of the direction of the packet.
+// Without the destination address only the session ID is used.
+func pairKey(a, b net.HardwareAddr, id uint16) sessionKey {
+	if a == nil || b == nil {
+		return sessionKey{"", id}
+	}
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return sessionKey{a.String() + "/" + b.String(), id}
+}
+
+func (d *captureDecoder) state(src, dst net.HardwareAddr, id uint16) *capturedState {
+	key := pairKey(src, dst, id)
+	st, ok := d.states[key]
+	if !ok {
+		st = &capturedState{
+			session:    &CapturedSession{ID: id},
+			challenges: make(map[byte][]byte),
+		}
+		d.states[key] = st
+		d.order = append(d.order, st.session)
+	}
+	return st
+}
+
+// setPeers records which side is the client once a packet tells it apart.
+func (st *capturedState) setPeers(client, ac net.HardwareAddr) {
+	if st.session.Client == nil {
+		st.session.Client = client
+	}
+	if st.session.AC == nil {
+		st.session.AC = ac
+	}
+}
+
+// capture adds a credential to the session followed by st.
+func (d *captureDecoder) capture(st *capturedState, credential *Credential) {
+	st.session.Credentials = append(st.session.Credentials, credential)
+	if d.onCredential != nil {
+		d.onCredential(st.session, credential)
+	}
+}
+
+func (d *captureDecoder) decode(packet gopacket.Packet) {
+	var src, dst net.HardwareAddr
+	if ethernetLayer := packet.Layer(layers.LayerTypeEthernet); ethernetLayer != nil {
+		ethernet := ethernetLayer.(*layers.Ethernet)
+		src, dst = ethernet.SrcMAC, ethernet.DstMAC
+	} else if sllLayer := packet.Layer(layers.LayerTypeLinuxSLL); sllLayer != nil {
+		// Captures on the "any" device only keep the source address.
+		src = sllLayer.(*layers.LinuxSLL).Addr
+	} else {
+		return
+	}
+	pppoeLayer := packet.Layer(layers.LayerTypePPPoE)
+	if pppoeLayer == nil {
+		return
+	}
+	pppoe := pppoeLayer.(*layers.PPPoE)
+	switch pppoe.Code {
+	case layers.PPPoECodePADO:
+		if acName := DecodeDiscoveryTags(pppoe.Payload).ACName; acName != "" {
+			d.acNames[src.String()] = acName
+		}
+	case layers.PPPoECodePADS:
+		if pppoe.SessionId == 0 {
+			return
+		}
+		st := d.state(src, dst, pppoe.SessionId)
+		st.setPeers(dst, src)
+		st.session.ACName = d.acNames[src.String()]
+	case layers.PPPoECodeSession:
+		pppLayer := packet.Layer(layers.LayerTypePPP)
+		if pppLayer == nil {
+			return
+		}
+		ppp := pppLayer.(*layers.PPP)
+		if len(ppp.Payload) < 4 {
+			return
+		}
+		d.decodePPP(d.state(src, dst, pppoe.SessionId), src, dst, ppp)
+	}
+}
+
+func (d *captureDecoder) decodePPP(st *capturedState, src, dst net.HardwareAddr, ppp *layers.PPP) {
+	session := st.session
+	switch ppp.PPPType {
+	case PPPTypeLCP:
+		var lcpLayer PPPLCP
+		lcpLayer.DecodeFromBytes(ppp.Payload)
+		if lcpLayer.Code != PPPLCPCodeConfigurationAck {
+			return
+		}
+		// The client acknowledges the protocol requested by the AC.
+		if authOption := FindLCPOption(lcpLayer.Options, PPPLCPOptionTypeAuthenticationProtocol); authOption != nil {
+			if auth, ok := ParseAuthProtocol(authOption.Data); ok {
+				session.AuthProtocol = auth
+				st.setPeers(src, dst)
+			}
+		}
+	case PPPTypePasswordAuthentication:
+		var passwdLayer PPPPasswdAuthentication
+		passwdLayer.DecodeFromBytes(ppp.Payload)
+		switch passwdLayer.Code {
+		case AuthenticateRequest:
+			st.setPeers(src, dst)
+			if len(passwdLayer.Options) == 0 {
+				return
+			}
+			authOption := passwdLayer.Options[0].(*PPPPasswdAuthRequestOption)
+			d.capture(st, &Credential{
+				Protocol: PPPTypePasswordAuthentication,
+				Username: string(authOption.PeerId),
+				Password: string(authOption.Passwd),
+			})
+		case AuthenticateACK:
+			session.Authenticated = true
+		}
+	case PPPTypeChallengeAuthentication:
+		var chapLayer PPPChallengeAuthentication
+		chapLayer.DecodeFromBytes(ppp.Payload)
+		switch chapLayer.Code {
+		case ChallengeRequest:
+			st.setPeers(dst, src)
+			st.challenges[chapLayer.Identifier] = chapLayer.Options[0].(*PPPChallengeValueOption).Value
+		case ChallengeResponse:
+			st.setPeers(src, dst)
+			challenge, ok := st.challenges[chapLayer.Identifier]
+			if !ok {
+				return
+			}
+			valueOption := chapLayer.Options[0].(*PPPChallengeValueOption)
+			d.capture(st, &Credential{
+				Protocol:   PPPTypeChallengeAuthentication,
+				Algorithm:  chapAlgorithm(session.AuthProtocol, challenge, valueOption.Value),
+				Username:   string(valueOption.Name),
+				Identifier: chapLayer.Identifier,
+				Challenge:  challenge,
+				Response:   valueOption.Value,
+			})
+		case ChallengeSuccess:
+			session.Authenticated = true
+		}
+	case PPPTypeEAP:
+		var eapLayer PPPEAP
+		eapLayer.DecodeFromBytes(ppp.Payload)
+		switch eapLayer.Code {
+		case EAPRequest:
+			if eapLayer.Type == EAPTypeMD5Challenge {
+				st.setPeers(dst, src)
+				st.challenges[eapLayer.Identifier] = eapLayer.Options[0].(*PPPChallengeValueOption).Value
+			}
+		case EAPResponse:
+			st.setPeers(src, dst)
+			switch eapLayer.Type {
+			case EAPTypeIdentity:
+				if len(eapLayer.Options) > 0 {
+					st.identity = string(eapLayer.Options[0].Content())
+				}
+			case EAPTypeMD5Challenge:
+				challenge, ok := st.challenges[eapLayer.Identifier]
+				if !ok {
+					return
+				}
+				d.capture(st, &Credential{
+					Protocol:   PPPTypeEAP,
+					Algorithm:  byte(EAPTypeMD5Challenge),
+					Username:   st.identity,
+					Identifier: eapLayer.Identifier,
+					Challenge:  challenge,
+					Response:   eapLayer.Options[0].(*PPPChallengeValueOption).Value,
+				})
+			}
+		case EAPSuccess:
+			session.Authenticated = true
+		}
+	}
+}
+
+// chapAlgorithm returns the CHAP algorithm negotiated by LCP, or guesses it
+// from the challenge and response sizes when the negotiation is missing from
+// the capture.
+func chapAlgorithm(auth AuthProtocol, challenge []byte, response []byte) byte {
+	if auth.Type == PPPTypeChallengeAuthentication {
+		return auth.Algorithm
+	}
+	if len(response) == msChapResponseLen {
+		if len(challenge) == 8 {
+			return ChallengeAlgorithmMSCHAPv1
+		}
+		return ChallengeAlgorithmMSCHAPv2
+	}
+	return ChallengeAlgorithmMD5
+}
@@ -0,0 +1,242 @@
+package pppoe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	testCaptureAC     = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	testCaptureClient = net.HardwareAddr{2, 0, 0, 0, 0, 2}
+)
+
+// capturedFrame is a PPPoE frame of a test capture. Discovery frames carry
+// tags in payload, session frames a PPP packet of protocol.
+type capturedFrame struct {
+	fromAC   bool
+	code     layers.PPPoECode
+	sid      uint16
+	protocol layers.PPPType
+	payload  []byte
+}
+
+func (f capturedFrame) bytes() []byte {
+	src, dst := testCaptureClient, testCaptureAC
+	if f.fromAC {
+		src, dst = dst, src
+	}
+	etherType := layers.EthernetTypePPPoEDiscovery
+	payload := f.payload
+	if f.code == layers.PPPoECodeSession {
+		etherType = layers.EthernetTypePPPoESession
+		payload = append(UInt16ToBytes(uint16(f.protocol)), payload...)
+	}
+	frame := append(append([]byte{}, dst...), src...)
+	frame = append(frame, UInt16ToBytes(uint16(etherType))...)
+	frame = append(frame, 0x11, byte(f.code))
+	frame = append(frame, UInt16ToBytes(f.sid)...)
+	frame = append(frame, UInt16ToBytes(uint16(len(payload)))...)
+	return append(frame, payload...)
+}
+
+// pppPacket returns a PPP control or authentication packet with its length
+// field filled in.
+func pppPacket(code byte, id byte, data ...byte) []byte {
+	packet := []byte{code, id, 0, 0}
+	packet = append(packet, data...)
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	return packet
+}
+
+func writeCapture(t *testing.T, frames []capturedFrame, ng bool) *bytes.Buffer {
+	var buf bytes.Buffer
+	var write func(ci gopacket.CaptureInfo, data []byte) error
+	if ng {
+		w, err := pcapgo.NewNgWriter(&buf, layers.LinkTypeEthernet)
+		if err != nil {
+			t.Fatalf("NewNgWriter: %v", err)
+		}
+		defer w.Flush()
+		write = w.WritePacket
+	} else {
+		w := pcapgo.NewWriter(&buf)
+		if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+			t.Fatalf("WriteFileHeader: %v", err)
+		}
+		write = w.WritePacket
+	}
+	for _, f := range frames {
+		data := f.bytes()
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(0, 0), CaptureLength: len(data), Length: len(data)}
+		if err := write(ci, data); err != nil {
+			t.Fatalf("WritePacket: %v", err)
+		}
+	}
+	return &buf
+}
+
+func TestDecodeCapture(t *testing.T) {
+	challenge := bytes.Repeat([]byte{0xcc}, 16)
+	response := bytes.Repeat([]byte{0xdd}, 16)
+	discovery := []capturedFrame{
+		{fromAC: true, code: layers.PPPoECodePADO, payload: PPPoETags([]PPPoETag{{TagName: TagNameACName, TagValue: "isp-ac"}})},
+		{fromAC: true, code: layers.PPPoECodePADS, sid: 7},
+	}
+	session := func(protocol layers.PPPType, fromAC bool, packet []byte) capturedFrame {
+		return capturedFrame{fromAC: fromAC, code: layers.PPPoECodeSession, sid: 7, protocol: protocol, payload: packet}
+	}
+	lcpAck := func(auth ...byte) capturedFrame {
+		return session(PPPTypeLCP, false, pppPacket(byte(PPPLCPCodeConfigurationAck), 1, append([]byte{3, byte(2 + len(auth))}, auth...)...))
+	}
+	chapValue := func(value []byte, name string) []byte {
+		return append(append([]byte{byte(len(value))}, value...), name...)
+	}
+	tests := []struct {
+		name              string
+		frames            []capturedFrame
+		wantAuth          AuthProtocol
+		wantAuthenticated bool
+		wantCredentials   []*Credential
+	}{
+		{
+			name: "PAP",
+			frames: append(discovery,
+				lcpAck(0xc0, 0x23),
+				session(PPPTypePasswordAuthentication, false, pppPacket(1, 1, append(append([]byte{4}, "user"...), append([]byte{6}, "secret"...)...)...)),
+				session(PPPTypePasswordAuthentication, true, pppPacket(2, 1, 0)),
+			),
+			wantAuth:          AuthPAP,
+			wantAuthenticated: true,
+			wantCredentials:   []*Credential{{Protocol: PPPTypePasswordAuthentication, Username: "user", Password: "secret"}},
+		},
+		{
+			name: "CHAP-MD5",
+			frames: append(discovery,
+				lcpAck(0xc2, 0x23, 5),
+				session(PPPTypeChallengeAuthentication, true, pppPacket(1, 9, chapValue(challenge, "ac")...)),
+				session(PPPTypeChallengeAuthentication, false, pppPacket(2, 9, chapValue(response, "user")...)),
+				session(PPPTypeChallengeAuthentication, true, pppPacket(3, 9)),
+			),
+			wantAuth:          AuthCHAPMD5,
+			wantAuthenticated: true,
+			wantCredentials: []*Credential{{
+				Protocol:   PPPTypeChallengeAuthentication,
+				Algorithm:  ChallengeAlgorithmMD5,
+				Username:   "user",
+				Identifier: 9,
+				Challenge:  challenge,
+				Response:   response,
+			}},
+		},
+		{
+			name: "MS-CHAPv2 without LCP",
+			frames: append(discovery,
+				session(PPPTypeChallengeAuthentication, true, pppPacket(1, 9, chapValue(challenge, "ac")...)),
+				session(PPPTypeChallengeAuthentication, false, pppPacket(2, 9, chapValue(make([]byte, msChapResponseLen), "user")...)),
+			),
+			wantCredentials: []*Credential{{
+				Protocol:   PPPTypeChallengeAuthentication,
+				Algorithm:  ChallengeAlgorithmMSCHAPv2,
+				Username:   "user",
+				Identifier: 9,
+				Challenge:  challenge,
+				Response:   make([]byte, msChapResponseLen),
+			}},
+		},
+		{
+			name: "EAP-MD5",
+			frames: append(discovery,
+				lcpAck(0xc2, 0x27),
+				session(PPPTypeEAP, false, pppPacket(2, 1, append([]byte{byte(EAPTypeIdentity)}, "user"...)...)),
+				session(PPPTypeEAP, true, pppPacket(1, 2, append([]byte{byte(EAPTypeMD5Challenge)}, chapValue(challenge, "")...)...)),
+				session(PPPTypeEAP, false, pppPacket(2, 2, append([]byte{byte(EAPTypeMD5Challenge)}, chapValue(response, "")...)...)),
+				session(PPPTypeEAP, true, pppPacket(3, 2)),
+			),
+			wantAuth:          AuthEAP,
+			wantAuthenticated: true,
+			wantCredentials: []*Credential{{
+				Protocol:   PPPTypeEAP,
+				Algorithm:  byte(EAPTypeMD5Challenge),
+				Username:   "user",
+				Identifier: 2,
+				Challenge:  challenge,
+				Response:   response,
+			}},
+		},
+		{
+			name: "CHAP response without challenge",
+			frames: append(discovery,
+				session(PPPTypeChallengeAuthentication, false, pppPacket(2, 9, chapValue(response, "user")...)),
+			),
+		},
+		{
+			name: "truncated packets",
+			frames: append(discovery,
+				session(PPPTypeLCP, false, []byte{2, 1}),
+				session(PPPTypePasswordAuthentication, false, pppPacket(1, 1, 40, 'u')),
+				session(PPPTypeChallengeAuthentication, true, pppPacket(1, 9, 200)),
+				session(PPPTypeEAP, true, pppPacket(1, 2, byte(EAPTypeMD5Challenge))),
+			),
+			wantCredentials: []*Credential{{Protocol: PPPTypePasswordAuthentication, Username: "u", Password: ""}},
+		},
+	}
+	for _, test := range tests {
+		for _, ng := range []bool{false, true} {
+			name := test.name + "/pcap"
+			if ng {
+				name = test.name + "/pcapng"
+			}
+			t.Run(name, func(t *testing.T) {
+				sessions, err := DecodeCapture(writeCapture(t, test.frames, ng))
+				if err != nil {
+					t.Fatalf("DecodeCapture: %v", err)
+				}
+				if len(sessions) != 1 {
+					t.Fatalf("decoded %d sessions, want 1", len(sessions))
+				}
+				s := sessions[0]
+				if s.ID != 7 || s.ACName != "isp-ac" || !bytes.Equal(s.AC, testCaptureAC) || !bytes.Equal(s.Client, testCaptureClient) {
+					t.Errorf("session %d of %s with %s (%q), want 7 of %s with %s (%q)", s.ID, s.Client, s.AC, s.ACName, testCaptureClient, testCaptureAC, "isp-ac")
+				}
+				if s.AuthProtocol != test.wantAuth {
+					t.Errorf("AuthProtocol = %v, want %v", s.AuthProtocol, test.wantAuth)
+				}
+				if s.Authenticated != test.wantAuthenticated {
+					t.Errorf("Authenticated = %v, want %v", s.Authenticated, test.wantAuthenticated)
+				}
+				if len(s.Credentials) != len(test.wantCredentials) {
+					t.Fatalf("decoded %d credentials, want %d", len(s.Credentials), len(test.wantCredentials))
+				}
+				for i, want := range test.wantCredentials {
+					if got := s.Credentials[i]; got.Hash() != want.Hash() || got.Username != want.Username || got.Password != want.Password {
+						t.Errorf("credential %d = %+v, want %+v", i, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeCaptureInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"unknown magic", []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"truncated pcapng", pcapngMagic},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := DecodeCapture(bytes.NewReader(test.data)); err == nil {
+				t.Error("DecodeCapture succeeded")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package pppoe
 
 import (
 	"encoding/binary"
+	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"net"
@@ -15,6 +16,18 @@ const (
 	AuthenticationNak   PPPAuthenticationCode = 3
 )
 
+func (c PPPAuthenticationCode) String() string {
+	switch c {
+	case AuthenticateRequest:
+		return "Authenticate-Request"
+	case AuthenticateACK:
+		return "Authenticate-Ack"
+	case AuthenticationNak:
+		return "Authenticate-Nak"
+	}
+	return fmt.Sprintf("Unknown Code %d", c)
+}
+
 type PPPPasswdAuthRequestOption struct {
 	PeerIdLength byte
 	PeerId       []byte
@@ -37,12 +50,23 @@ func (m *PPPPasswdAuthRequestOption) Len() int {
 
 func DecodePPPPasswdAuthRequestOption(data []byte) []Option {
 	var option PPPPasswdAuthRequestOption
+	if len(data) == 0 {
+		return []Option{&option}
+	}
 	option.PeerIdLength = data[0]
-	i := 1 + option.PeerIdLength
+	i := 1 + int(option.PeerIdLength)
+	if i >= len(data) {
+		option.PeerId = data[1:]
+		return []Option{&option}
+	}
 	option.PeerId = data[1:i]
 	option.PasswdLength = data[i]
 	i += 1
-	option.Passwd = data[i:(i + option.PasswdLength)]
+	end := i + int(option.PasswdLength)
+	if end > len(data) {
+		end = len(data)
+	}
+	option.Passwd = data[i:end]
 	return []Option{&option}
 }
 
@@ -63,8 +87,11 @@ func (m *PPPPasswdAuthResultOption) Len() int {
 }
 
 func DecodePPPPasswdAuthResultOption(data []byte) []Option {
+	if len(data) == 0 {
+		return []Option{&PPPPasswdAuthResultOption{Message: make([]byte, 0)}}
+	}
 	message := make([]byte, 0)
-	if data[0] > 0 {
+	if int(data[0]) < len(data) {
 		message = data[1:(1 + data[0])]
 	}
 	return []Option{&PPPPasswdAuthResultOption{MessageLength: data[0], Message: message}}
@@ -80,8 +107,8 @@ type PPPPasswdAuthentication struct {
 var LayerTypePPPPasswdAuthentication = gopacket.RegisterLayerType(
 	2002,
 	gopacket.LayerTypeMetadata{
-		"LayerTypePPPPasswdAuthentication",
-		gopacket.DecodeFunc(nil),
+		Name:    "LayerTypePPPPasswdAuthentication",
+		Decoder: gopacket.DecodeFunc(nil),
 	},
 )
 
@@ -120,6 +147,9 @@ func (m *PPPPasswdAuthentication) DecodeFromBytes(data []byte) {
 	m.Code = PPPAuthenticationCode(data[0])
 	m.Identifier = data[1]
 	m.Length = binary.BigEndian.Uint16(data[2:4])
+	if int(m.Length) > len(data) || m.Length < 4 {
+		m.Length = uint16(len(data))
+	}
 	switch m.Code {
 	case AuthenticateRequest:
 		m.Options = DecodePPPPasswdAuthRequestOption(data[4:m.Length])
@@ -129,10 +159,12 @@ func (m *PPPPasswdAuthentication) DecodeFromBytes(data []byte) {
 	}
 }
 
-func sendPPPPasswdAuthentication(dst net.HardwareAddr, auth PPPAuthenticationCode, sid uint16, id byte, options []Option) {
-	pppLayer := layers.PPP{}
-	pppLayer.PPPType = PPPTypeLCP
+func (s *Server) sendPPPPasswdAuthentication(dst net.HardwareAddr, auth PPPAuthenticationCode, sid uint16, id byte, options []Option) {
+	payload := encodePPPPasswdAuthentication(auth, id, options)
+	s.sendPacket(dst, payload, layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(payload)))
+}
 
+func encodePPPPasswdAuthentication(auth PPPAuthenticationCode, id byte, options []Option) []byte {
 	optionsLen := 0
 	for _, op := range options {
 		optionsLen += op.Len()
@@ -151,5 +183,5 @@ func sendPPPPasswdAuthentication(dst net.HardwareAddr, auth PPPAuthenticationCod
 			Options:    options,
 		},
 	)
-	sendPacket(dst, buffer.Bytes(), layers.PPPoECodeSession, sid, layers.EthernetTypePPPoESession, uint16(len(buffer.Bytes())))
+	return buffer.Bytes()
 }
@@ -1,27 +1,112 @@
 package pppoe
 
 import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
 	"github.com/google/gopacket/layers"
 	"net"
 )
 
+const (
+	defaultACName       = "Simulator"
+	defaultCookieLength = 16
+)
+
 type TagName uint16
 
 const (
-	TagNameServiceName TagName = 0x0101
-	TagNameACName      TagName = 0x0102
-	TagNameHostUniq    TagName = 0x0103
-	TagNameACCookie    TagName = 0x0104
+	TagNameEndOfList        TagName = 0x0000
+	TagNameServiceName      TagName = 0x0101
+	TagNameACName           TagName = 0x0102
+	TagNameHostUniq         TagName = 0x0103
+	TagNameACCookie         TagName = 0x0104
+	TagNameVendorSpecific   TagName = 0x0105
+	TagNameRelaySessionID   TagName = 0x0110
+	TagNamePPPMaxPayload    TagName = 0x0120
+	TagNameServiceNameError TagName = 0x0201
+	TagNameACSystemError    TagName = 0x0202
+	TagNameGenericError     TagName = 0x0203
+)
+
+var tagNames = map[TagName]string{
+	TagNameEndOfList:        "End-Of-List",
+	TagNameServiceName:      "Service-Name",
+	TagNameACName:           "AC-Name",
+	TagNameHostUniq:         "Host-Uniq",
+	TagNameACCookie:         "AC-Cookie",
+	TagNameVendorSpecific:   "Vendor-Specific",
+	TagNameRelaySessionID:   "Relay-Session-Id",
+	TagNamePPPMaxPayload:    "PPP-Max-Payload",
+	TagNameServiceNameError: "Service-Name-Error",
+	TagNameACSystemError:    "AC-System-Error",
+	TagNameGenericError:     "Generic-Error",
+}
+
+func (t TagName) String() string {
+	if name, ok := tagNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Unknown(0x%04x)", uint16(t))
+}
+
+// ServicePolicy selects the Service-Names a server accepts.
+type ServicePolicy string
+
+const (
+	// ServiceAny accepts every Service-Name.
+	ServiceAny ServicePolicy = "any"
+	// ServiceList accepts the offered Service-Names and the empty one,
+	// which requests any service.
+	ServiceList ServicePolicy = "list"
+	// ServiceExact only accepts the offered Service-Names.
+	ServiceExact ServicePolicy = "exact"
 )
 
+// ParseServicePolicy returns the policy named name.
+func ParseServicePolicy(name string) (ServicePolicy, bool) {
+	switch policy := ServicePolicy(name); policy {
+	case ServiceAny, ServiceList, ServiceExact:
+		return policy, true
+	}
+	return "", false
+}
+
+// accepts tells whether a client requesting service may be served.
+func (p ServicePolicy) accepts(offered []string, service string) bool {
+	if p == ServiceAny || (p == ServiceList && service == "") {
+		return true
+	}
+	for _, name := range offered {
+		if name == service {
+			return true
+		}
+	}
+	return false
+}
+
 type PPPoETag struct {
 	TagName  TagName
 	TagValue interface{}
 }
 
+// PPPoETags serializes discovery tags, starting with an empty Service-Name
+// unless the tags contain one.
 func PPPoETags(tags []PPPoETag) []byte {
 	payload := make([]byte, 0)
-	payload = append(payload, []byte{1, 1, 0, 0}...)
+	hasServiceName := false
+	for _, tag := range tags {
+		hasServiceName = hasServiceName || tag.TagName == TagNameServiceName
+	}
+	if !hasServiceName {
+		payload = append(payload, []byte{1, 1, 0, 0}...)
+	}
+	return append(payload, encodeTags(tags)...)
+}
+
+// encodeTags serializes tags as they are.
+func encodeTags(tags []PPPoETag) []byte {
+	payload := make([]byte, 0)
 	for _, tag := range tags {
 		payload = append(payload, UInt16ToBytes(uint16(tag.TagName))...)
 		if tagStr, ok := tag.TagValue.(string); ok {
@@ -35,15 +120,172 @@ func PPPoETags(tags []PPPoETag) []byte {
 	return payload
 }
 
-func sendPADO(dst net.HardwareAddr, tags []PPPoETag) {
+// ParsePPPoETags splits the payload of a discovery packet into its tags.
+// The values are returned as []byte. Parsing stops at End-Of-List and at the
+// first truncated tag.
+func ParsePPPoETags(payload []byte) []PPPoETag {
+	tags := make([]PPPoETag, 0)
+	for len(payload) >= 4 {
+		name := TagName(binary.BigEndian.Uint16(payload[:2]))
+		length := int(binary.BigEndian.Uint16(payload[2:4]))
+		if name == TagNameEndOfList || 4+length > len(payload) {
+			break
+		}
+		tags = append(tags, PPPoETag{name, payload[4 : 4+length]})
+		payload = payload[4+length:]
+	}
+	return tags
+}
+
+// DiscoveryTags holds the decoded tags of a discovery packet. Byte values are
+// nil when the tag is absent, and ServiceNames is nil when no Service-Name
+// tag is present; an empty name requests any service.
+type DiscoveryTags struct {
+	ServiceNames     []string
+	ACName           string
+	HostUniq         []byte
+	ACCookie         []byte
+	VendorSpecific   [][]byte
+	RelaySessionID   []byte
+	PPPMaxPayload    uint16
+	ServiceNameError *string
+	ACSystemError    *string
+	GenericError     *string
+	Unknown          []PPPoETag
+}
+
+// DecodeDiscoveryTags decodes the tags of a discovery packet payload. Tags
+// that may only appear once keep their first value.
+func DecodeDiscoveryTags(payload []byte) *DiscoveryTags {
+	t := &DiscoveryTags{}
+	for _, tag := range ParsePPPoETags(payload) {
+		value := tag.TagValue.([]byte)
+		switch tag.TagName {
+		case TagNameServiceName:
+			t.ServiceNames = append(t.ServiceNames, string(value))
+		case TagNameACName:
+			if t.ACName == "" {
+				t.ACName = string(value)
+			}
+		case TagNameHostUniq:
+			if t.HostUniq == nil {
+				t.HostUniq = value
+			}
+		case TagNameACCookie:
+			if t.ACCookie == nil {
+				t.ACCookie = value
+			}
+		case TagNameVendorSpecific:
+			t.VendorSpecific = append(t.VendorSpecific, value)
+		case TagNameRelaySessionID:
+			if t.RelaySessionID == nil {
+				t.RelaySessionID = value
+			}
+		case TagNamePPPMaxPayload:
+			if len(value) == 2 && t.PPPMaxPayload == 0 {
+				t.PPPMaxPayload = binary.BigEndian.Uint16(value)
+			}
+		case TagNameServiceNameError:
+			t.ServiceNameError = errorTag(t.ServiceNameError, value)
+		case TagNameACSystemError:
+			t.ACSystemError = errorTag(t.ACSystemError, value)
+		case TagNameGenericError:
+			t.GenericError = errorTag(t.GenericError, value)
+		default:
+			t.Unknown = append(t.Unknown, tag)
+		}
+	}
+	return t
+}
+
+// errorTag keeps the first value of an error tag, whose message may be empty.
+func errorTag(current *string, value []byte) *string {
+	if current != nil {
+		return current
+	}
+	message := string(value)
+	return &message
+}
+
+// ServiceName returns the first Service-Name, the one a PADR requests.
+func (t *DiscoveryTags) ServiceName() string {
+	if len(t.ServiceNames) == 0 {
+		return ""
+	}
+	return t.ServiceNames[0]
+}
+
+// Error returns the first error tag of the packet, if any.
+func (t *DiscoveryTags) Error() (TagName, string, bool) {
+	switch {
+	case t.ServiceNameError != nil:
+		return TagNameServiceNameError, *t.ServiceNameError, true
+	case t.ACSystemError != nil:
+		return TagNameACSystemError, *t.ACSystemError, true
+	case t.GenericError != nil:
+		return TagNameGenericError, *t.GenericError, true
+	}
+	return 0, "", false
+}
+
+// Echo returns the tags a response must copy unchanged from the request:
+// Host-Uniq and Relay-Session-Id (RFC 2516, section 5).
+func (t *DiscoveryTags) Echo() []PPPoETag {
+	tags := make([]PPPoETag, 0, 2)
+	if t.HostUniq != nil {
+		tags = append(tags, PPPoETag{TagNameHostUniq, t.HostUniq})
+	}
+	if t.RelaySessionID != nil {
+		tags = append(tags, PPPoETag{TagNameRelaySessionID, t.RelaySessionID})
+	}
+	return tags
+}
+
+// fields returns the tags of a request as event fields.
+func (t *DiscoveryTags) fields() map[string]interface{} {
+	fields := make(map[string]interface{})
+	if t.ServiceNames != nil {
+		fields["service_names"] = t.ServiceNames
+	}
+	if t.ACName != "" {
+		fields["ac_name"] = t.ACName
+	}
+	if t.HostUniq != nil {
+		fields["host_uniq"] = hex.EncodeToString(t.HostUniq)
+	}
+	if t.ACCookie != nil {
+		fields["ac_cookie"] = hex.EncodeToString(t.ACCookie)
+	}
+	if t.RelaySessionID != nil {
+		fields["relay_session_id"] = hex.EncodeToString(t.RelaySessionID)
+	}
+	if t.PPPMaxPayload != 0 {
+		fields["ppp_max_payload"] = t.PPPMaxPayload
+	}
+	if name, message, ok := t.Error(); ok {
+		fields["error"] = fmt.Sprintf("%s: %s", name, message)
+	}
+	return fields
+}
+
+func (s *Server) sendPADO(dst net.HardwareAddr, tags []PPPoETag) {
+	pppoeTags := PPPoETags(tags)
+	s.sendPacket(dst, pppoeTags, layers.PPPoECodePADO, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(pppoeTags)))
+}
+
+func (s *Server) sendPADS(dst net.HardwareAddr, sid uint16, tags []PPPoETag) {
 	pppoeTags := PPPoETags(tags)
-	sendPacket(dst, pppoeTags, layers.PPPoECodePADO, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(pppoeTags)))
+	s.sendPacket(dst, pppoeTags, layers.PPPoECodePADS, sid, layers.EthernetTypePPPoEDiscovery, uint16(len(pppoeTags)))
 }
 
-func sendPADS(dst net.HardwareAddr, tags []byte) {
-	sendPacket(dst, tags, layers.PPPoECodePADS, 1, layers.EthernetTypePPPoEDiscovery, uint16(len(tags)))
+// sendPADSError refuses a PADR with a PADS carrying session ID 0 and the
+// error tag name (RFC 2516, section 5.4).
+func (s *Server) sendPADSError(dst net.HardwareAddr, request *DiscoveryTags, name TagName, message string) {
+	tags := []PPPoETag{{TagNameServiceName, request.ServiceName()}, {TagNameACName, s.config.ACName}, {name, message}}
+	s.sendPADS(dst, 0, append(tags, request.Echo()...))
+	s.emit(Event{Type: EventError, Direction: EventOutgoing, Peer: dst, Protocol: "PPPoED", Message: fmt.Sprintf("Active Discovery Session-confirmation (PADS), %s: %s", name, message)})
 }
 
-func sendPADT(dst net.HardwareAddr, tags []byte) {
-	sendPacket(dst, tags, layers.PPPoECodePADT, 1, layers.EthernetTypePPPoEDiscovery, uint16(len(tags)))
+func (s *Server) sendPADT(dst net.HardwareAddr, sid uint16, tags []byte) {
+	s.sendPacket(dst, tags, layers.PPPoECodePADT, sid, layers.EthernetTypePPPoEDiscovery, uint16(len(tags)))
 }
@@ -0,0 +1,198 @@
+package pppoe
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// rawTag encodes one discovery tag.
+func rawTag(name TagName, value string) []byte {
+	tag := append(UInt16ToBytes(uint16(name)), UInt16ToBytes(uint16(len(value)))...)
+	return append(tag, value...)
+}
+
+func joinTags(tags ...[]byte) []byte {
+	return bytes.Join(tags, nil)
+}
+
+func stringPointer(s string) *string {
+	return &s
+}
+
+func TestDecodeDiscoveryTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    *DiscoveryTags
+	}{
+		{
+			name:    "empty",
+			payload: []byte{},
+			want:    &DiscoveryTags{},
+		},
+		{
+			name:    "any service",
+			payload: rawTag(TagNameServiceName, ""),
+			want:    &DiscoveryTags{ServiceNames: []string{""}},
+		},
+		{
+			name: "all tags",
+			payload: joinTags(
+				rawTag(TagNameServiceName, "isp"),
+				rawTag(TagNameACName, "ac"),
+				rawTag(TagNameHostUniq, "\x01\x02"),
+				rawTag(TagNameACCookie, "\x03\x04"),
+				rawTag(TagNameVendorSpecific, "\x00\x00\x0d\xe9"),
+				rawTag(TagNameRelaySessionID, "\x05"),
+				rawTag(TagNamePPPMaxPayload, "\x05\xdc"),
+				rawTag(TagName(0x0199), "x"),
+			),
+			want: &DiscoveryTags{
+				ServiceNames:   []string{"isp"},
+				ACName:         "ac",
+				HostUniq:       []byte{1, 2},
+				ACCookie:       []byte{3, 4},
+				VendorSpecific: [][]byte{{0, 0, 0x0d, 0xe9}},
+				RelaySessionID: []byte{5},
+				PPPMaxPayload:  1500,
+				Unknown:        []PPPoETag{{TagName: TagName(0x0199), TagValue: []byte("x")}},
+			},
+		},
+		{
+			name: "repeated tags",
+			payload: joinTags(
+				rawTag(TagNameServiceName, "a"),
+				rawTag(TagNameServiceName, "b"),
+				rawTag(TagNameHostUniq, "\x01"),
+				rawTag(TagNameHostUniq, "\x02"),
+				rawTag(TagNameACName, "first"),
+				rawTag(TagNameACName, "second"),
+			),
+			want: &DiscoveryTags{ServiceNames: []string{"a", "b"}, ACName: "first", HostUniq: []byte{1}},
+		},
+		{
+			name: "errors",
+			payload: joinTags(
+				rawTag(TagNameGenericError, ""),
+				rawTag(TagNameServiceNameError, "no such service"),
+				rawTag(TagNameServiceNameError, "ignored"),
+			),
+			want: &DiscoveryTags{ServiceNameError: stringPointer("no such service"), GenericError: stringPointer("")},
+		},
+		{
+			name:    "malformed PPP-Max-Payload",
+			payload: rawTag(TagNamePPPMaxPayload, "\x05"),
+			want:    &DiscoveryTags{},
+		},
+		{
+			name:    "stops at End-Of-List",
+			payload: joinTags(rawTag(TagNameACName, "ac"), rawTag(TagNameEndOfList, ""), rawTag(TagNameHostUniq, "\x01")),
+			want:    &DiscoveryTags{ACName: "ac"},
+		},
+		{
+			name:    "truncated value",
+			payload: joinTags(rawTag(TagNameACName, "ac"), rawTag(TagNameHostUniq, "\x01\x02")[:5]),
+			want:    &DiscoveryTags{ACName: "ac"},
+		},
+		{
+			name:    "truncated header",
+			payload: joinTags(rawTag(TagNameACName, "ac"), []byte{0x01, 0x03, 0x00}),
+			want:    &DiscoveryTags{ACName: "ac"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DecodeDiscoveryTags(test.payload); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("DecodeDiscoveryTags = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiscoveryTagsError(t *testing.T) {
+	tests := []struct {
+		name        string
+		tags        *DiscoveryTags
+		wantName    TagName
+		wantMessage string
+		wantOK      bool
+	}{
+		{"none", &DiscoveryTags{}, 0, "", false},
+		{"generic", &DiscoveryTags{GenericError: stringPointer("")}, TagNameGenericError, "", true},
+		{"service name first", &DiscoveryTags{ServiceNameError: stringPointer("a"), ACSystemError: stringPointer("b")}, TagNameServiceNameError, "a", true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			name, message, ok := test.tags.Error()
+			if name != test.wantName || message != test.wantMessage || ok != test.wantOK {
+				t.Errorf("Error = %s, %q, %v, want %s, %q, %v", name, message, ok, test.wantName, test.wantMessage, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestDiscoveryTagsEcho(t *testing.T) {
+	tests := []struct {
+		name string
+		tags *DiscoveryTags
+		want []PPPoETag
+	}{
+		{"none", &DiscoveryTags{ACCookie: []byte{1}}, []PPPoETag{}},
+		{"Host-Uniq", &DiscoveryTags{HostUniq: []byte{1}}, []PPPoETag{{TagName: TagNameHostUniq, TagValue: []byte{1}}}},
+		{
+			"both",
+			&DiscoveryTags{HostUniq: []byte{1}, RelaySessionID: []byte{2}},
+			[]PPPoETag{{TagName: TagNameHostUniq, TagValue: []byte{1}}, {TagName: TagNameRelaySessionID, TagValue: []byte{2}}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.tags.Echo(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Echo = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestServicePolicy(t *testing.T) {
+	offered := []string{"isp", "voip"}
+	tests := []struct {
+		policy  ServicePolicy
+		service string
+		want    bool
+	}{
+		{ServiceAny, "", true},
+		{ServiceAny, "other", true},
+		{ServiceList, "", true},
+		{ServiceList, "voip", true},
+		{ServiceList, "other", false},
+		{ServiceExact, "", false},
+		{ServiceExact, "isp", true},
+		{ServiceExact, "other", false},
+	}
+	for _, test := range tests {
+		if got := test.policy.accepts(offered, test.service); got != test.want {
+			t.Errorf("%s policy accepts %q = %v, want %v", test.policy, test.service, got, test.want)
+		}
+	}
+}
+
+func TestParseServicePolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		want   ServicePolicy
+		wantOK bool
+	}{
+		{"any", ServiceAny, true},
+		{"list", ServiceList, true},
+		{"exact", ServiceExact, true},
+		{"Exact", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		if got, ok := ParseServicePolicy(test.name); got != test.want || ok != test.wantOK {
+			t.Errorf("ParseServicePolicy(%q) = %q, %v, want %q, %v", test.name, got, ok, test.want, test.wantOK)
+		}
+	}
+}
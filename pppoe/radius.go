@@ -0,0 +1,285 @@
+package pppoe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RADIUS packet codes (RFC 2865 section 3).
+const (
+	radiusAccessRequest   byte = 1
+	radiusAccessAccept    byte = 2
+	radiusAccessReject    byte = 3
+	radiusAccessChallenge byte = 11
+)
+
+// RADIUS attribute types (RFC 2865 section 5, RFC 2869 section 5.14).
+const (
+	radiusUserName             byte = 1
+	radiusUserPassword         byte = 2
+	radiusCHAPPassword         byte = 3
+	radiusNASPort              byte = 5
+	radiusServiceType          byte = 6
+	radiusFramedProtocol       byte = 7
+	radiusFramedIPAddress      byte = 8
+	radiusReplyMessage         byte = 18
+	radiusCallingStationID     byte = 31
+	radiusNASIdentifier        byte = 32
+	radiusCHAPChallenge        byte = 60
+	radiusNASPortType          byte = 61
+	radiusMessageAuthenticator byte = 80
+)
+
+const (
+	radiusServiceTypeFramed  = 2
+	radiusFramedProtocolPPP  = 1
+	radiusNASPortTypeVirtual = 5
+
+	radiusHeaderLen    = 20
+	radiusMaxPacketLen = 4096
+	radiusDefaultPort  = "1812"
+)
+
+const (
+	DefaultRADIUSTimeout = 3 * time.Second
+	DefaultRADIUSRetries = 3
+)
+
+// RADIUSAuthenticator is an Authenticator asking a RADIUS server (RFC 2865)
+// with an Access-Request per credential. Access-Accept accepts the peer,
+// with its Framed-IP-Address if any, and the Reply-Message of an
+// Access-Reject is sent back to it.
+type RADIUSAuthenticator struct {
+	// Address is the host and port of the server, port 1812 if omitted.
+	Address string
+	Secret  string
+	// NASIdentifier is sent in the NAS-Identifier attribute, the AC-Name of
+	// the request if empty.
+	NASIdentifier string
+	// Timeout bounds each attempt and Retries is the number of attempts,
+	// DefaultRADIUSTimeout and DefaultRADIUSRetries if zero.
+	Timeout time.Duration
+	Retries int
+
+	identifier uint32
+}
+
+func (r *RADIUSAuthenticator) Authenticate(ctx context.Context, request *AuthRequest) (*AuthResponse, error) {
+	address := r.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, radiusDefaultPort)
+	}
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultRADIUSTimeout
+	}
+	retries := r.Retries
+	if retries == 0 {
+		retries = DefaultRADIUSRetries
+	}
+	packet, err := r.accessRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		// Unblock the read when ctx is cancelled.
+		<-ctx.Done()
+		conn.SetDeadline(time.Now())
+	}()
+	buf := make([]byte, radiusMaxPacketLen)
+	for attempt := 0; attempt < retries; attempt++ {
+		if _, err = conn.Write(packet); err != nil {
+			return nil, err
+		}
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		for {
+			n, err := conn.Read(buf)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if err != nil {
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			if response, ok := r.parseResponse(buf[:n], packet); ok {
+				return response, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("pppoe: no response from RADIUS server %s", address)
+}
+
+// accessRequest encodes the Access-Request of a credential.
+func (r *RADIUSAuthenticator) accessRequest(request *AuthRequest) ([]byte, error) {
+	credential := request.Credential
+	authenticator, err := secureRandomBytes(16)
+	if err != nil {
+		return nil, err
+	}
+	packet := []byte{radiusAccessRequest, byte(atomic.AddUint32(&r.identifier, 1)), 0, 0}
+	packet = append(packet, authenticator...)
+	packet = appendRADIUSAttribute(packet, radiusUserName, []byte(credential.Username))
+	switch {
+	case credential.Protocol == PPPTypePasswordAuthentication:
+		packet = appendRADIUSAttribute(packet, radiusUserPassword, radiusHidePassword(credential.Password, r.Secret, authenticator))
+	case len(credential.Response) == md5.Size:
+		// EAP-MD5 computes its response like CHAP-MD5 (RFC 3748 section
+		// 5.4), so both are checked as CHAP.
+		packet = appendRADIUSAttribute(packet, radiusCHAPPassword, append([]byte{credential.Identifier}, credential.Response...))
+		packet = appendRADIUSAttribute(packet, radiusCHAPChallenge, credential.Challenge)
+	default:
+		return nil, fmt.Errorf("pppoe: %s credentials cannot be checked with RADIUS", credential.AuthProtocol())
+	}
+	packet = appendRADIUSAttribute(packet, radiusServiceType, UInt32ToBytes(radiusServiceTypeFramed))
+	packet = appendRADIUSAttribute(packet, radiusFramedProtocol, UInt32ToBytes(radiusFramedProtocolPPP))
+	packet = appendRADIUSAttribute(packet, radiusNASPort, UInt32ToBytes(uint32(request.SessionID)))
+	packet = appendRADIUSAttribute(packet, radiusNASPortType, UInt32ToBytes(radiusNASPortTypeVirtual))
+	if request.Peer != nil {
+		// RFC 3580 section 3.21 format, e.g. 00-10-A4-23-19-C0.
+		callingStation := strings.ToUpper(strings.Replace(request.Peer.String(), ":", "-", -1))
+		packet = appendRADIUSAttribute(packet, radiusCallingStationID, []byte(callingStation))
+	}
+	// RFC 2865 section 4.1 requires a NAS-Identifier or NAS-IP-Address.
+	nasIdentifier := r.NASIdentifier
+	if nasIdentifier == "" {
+		nasIdentifier = request.ACName
+	}
+	if nasIdentifier != "" {
+		packet = appendRADIUSAttribute(packet, radiusNASIdentifier, []byte(nasIdentifier))
+	}
+	// The Message-Authenticator is the HMAC-MD5 of the packet with the
+	// attribute zeroed (RFC 3579 section 3.2).
+	packet = appendRADIUSAttribute(packet, radiusMessageAuthenticator, make([]byte, md5.Size))
+	if len(packet) > radiusMaxPacketLen {
+		return nil, errors.New("pppoe: RADIUS request too long")
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	mac := hmac.New(md5.New, []byte(r.Secret))
+	mac.Write(packet)
+	copy(packet[len(packet)-md5.Size:], mac.Sum(nil))
+	return packet, nil
+}
+
+// parseResponse decodes the answer to request, and reports false if the
+// packet is not a valid answer to it.
+func (r *RADIUSAuthenticator) parseResponse(packet []byte, request []byte) (*AuthResponse, bool) {
+	if len(packet) < radiusHeaderLen || packet[1] != request[1] {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(packet[2:4]))
+	if length < radiusHeaderLen || length > len(packet) {
+		return nil, false
+	}
+	packet = packet[:length]
+	// Response Authenticator: MD5(Code+ID+Length+RequestAuth+Attributes+Secret)
+	hash := md5.New()
+	hash.Write(packet[:4])
+	hash.Write(request[4:radiusHeaderLen])
+	hash.Write(packet[radiusHeaderLen:])
+	hash.Write([]byte(r.Secret))
+	if !hmac.Equal(hash.Sum(nil), packet[4:radiusHeaderLen]) {
+		return nil, false
+	}
+	response := &AuthResponse{}
+	messages := make([]string, 0)
+	attributes := packet[radiusHeaderLen:]
+	for len(attributes) >= 2 {
+		attrType, attrLen := attributes[0], int(attributes[1])
+		if attrLen < 2 || attrLen > len(attributes) {
+			return nil, false
+		}
+		value := attributes[2:attrLen]
+		switch attrType {
+		case radiusReplyMessage:
+			messages = append(messages, string(value))
+		case radiusFramedIPAddress:
+			// 255.255.255.255 and 255.255.255.254 let the NAS choose.
+			if ip := net.IP(value); len(value) == net.IPv4len && !ip.Equal(net.IPv4bcast) && !ip.Equal(net.IPv4(255, 255, 255, 254)) {
+				response.FramedAddress = net.IP(append([]byte(nil), value...))
+			}
+		case radiusMessageAuthenticator:
+			if !r.checkMessageAuthenticator(packet, request, length-len(attributes)+2) {
+				return nil, false
+			}
+		}
+		attributes = attributes[attrLen:]
+	}
+	response.Message = strings.Join(messages, "")
+	switch packet[0] {
+	case radiusAccessAccept:
+		response.Accept = true
+	case radiusAccessReject:
+	case radiusAccessChallenge:
+		// Challenges are only used by EAP, which is not proxied.
+		if response.Message == "" {
+			response.Message = "Access-Challenge not supported"
+		}
+	default:
+		return nil, false
+	}
+	return response, true
+}
+
+// checkMessageAuthenticator verifies the Message-Authenticator of a
+// response whose value starts at offset.
+func (r *RADIUSAuthenticator) checkMessageAuthenticator(packet []byte, request []byte, offset int) bool {
+	if offset+md5.Size > len(packet) {
+		return false
+	}
+	data := append([]byte(nil), packet...)
+	copy(data[4:radiusHeaderLen], request[4:radiusHeaderLen])
+	copy(data[offset:offset+md5.Size], make([]byte, md5.Size))
+	mac := hmac.New(md5.New, []byte(r.Secret))
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), packet[offset:offset+md5.Size])
+}
+
+func appendRADIUSAttribute(packet []byte, attrType byte, value []byte) []byte {
+	if len(value) > 253 {
+		value = value[:253]
+	}
+	packet = append(packet, attrType, byte(2+len(value)))
+	return append(packet, value...)
+}
+
+// radiusHidePassword encrypts a User-Password (RFC 2865 section 5.2): the
+// password padded to 16 bytes is XORed with a chain of MD5 digests of the
+// secret.
+func radiusHidePassword(password string, secret string, authenticator []byte) []byte {
+	data := []byte(password)
+	if len(data) > 128 {
+		data = data[:128]
+	}
+	padded := make([]byte, (len(data)+15)/16*16)
+	if len(padded) == 0 {
+		padded = make([]byte, 16)
+	}
+	copy(padded, data)
+	previous := authenticator
+	for i := 0; i < len(padded); i += 16 {
+		digest := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			padded[i+j] ^= digest[j]
+		}
+		previous = padded[i : i+16]
+	}
+	return padded
+}
@@ -0,0 +1,349 @@
+package pppoe
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRADIUSSecret = "testing123"
+
+// fakeRADIUSServer answers the Access-Requests sent to it by checking the
+// credentials against one account.
+type fakeRADIUSServer struct {
+	conn     net.PacketConn
+	username string
+	password string
+	// secret signs the responses. drop is the number of requests ignored
+	// before the first response.
+	secret   string
+	drop     int32
+	framedIP net.IP
+	requests int32
+	// nasIdentifiers, if set, receives the NAS-Identifier of each valid
+	// request.
+	nasIdentifiers chan string
+}
+
+func newFakeRADIUSServer(t *testing.T, server *fakeRADIUSServer) *fakeRADIUSServer {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	server.conn = conn
+	go server.serve()
+	return server
+}
+
+func (s *fakeRADIUSServer) serve() {
+	buf := make([]byte, radiusMaxPacketLen)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request := append([]byte(nil), buf[:n]...)
+		if atomic.AddInt32(&s.requests, 1) <= s.drop {
+			continue
+		}
+		if response := s.respond(request); response != nil {
+			s.conn.WriteTo(response, addr)
+		}
+	}
+}
+
+// respond returns the response to request, nil if the request is invalid.
+func (s *fakeRADIUSServer) respond(request []byte) []byte {
+	if len(request) < radiusHeaderLen || request[0] != radiusAccessRequest {
+		return nil
+	}
+	attributes := make(map[byte][]byte)
+	offset := radiusHeaderLen
+	messageAuthenticator := -1
+	for offset+2 <= len(request) {
+		attrType, attrLen := request[offset], int(request[offset+1])
+		if attrType == radiusMessageAuthenticator {
+			messageAuthenticator = offset + 2
+		}
+		attributes[attrType] = request[offset+2 : offset+attrLen]
+		offset += attrLen
+	}
+	if messageAuthenticator < 0 {
+		return nil
+	}
+	data := append([]byte(nil), request...)
+	copy(data[messageAuthenticator:messageAuthenticator+md5.Size], make([]byte, md5.Size))
+	mac := hmac.New(md5.New, []byte(testRADIUSSecret))
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), request[messageAuthenticator:messageAuthenticator+md5.Size]) {
+		return nil
+	}
+
+	if s.nasIdentifiers != nil {
+		s.nasIdentifiers <- string(attributes[radiusNASIdentifier])
+	}
+	accept := string(attributes[radiusUserName]) == s.username
+	if hidden, ok := attributes[radiusUserPassword]; ok {
+		accept = accept && radiusRevealPassword(hidden, testRADIUSSecret, request[4:radiusHeaderLen]) == s.password
+	} else {
+		chapPassword := attributes[radiusCHAPPassword]
+		accept = accept && len(chapPassword) == 1+md5.Size &&
+			hmac.Equal(chapPassword[1:], chapMD5Response(chapPassword[0], s.password, attributes[radiusCHAPChallenge]))
+	}
+
+	response := []byte{radiusAccessReject, request[1], 0, 0}
+	response = append(response, make([]byte, 16)...)
+	if accept {
+		response[0] = radiusAccessAccept
+		if s.framedIP != nil {
+			response = appendRADIUSAttribute(response, radiusFramedIPAddress, s.framedIP.To4())
+		}
+	} else {
+		response = appendRADIUSAttribute(response, radiusReplyMessage, []byte("bad password"))
+	}
+	binary.BigEndian.PutUint16(response[2:4], uint16(len(response)))
+	hash := md5.New()
+	hash.Write(response[:4])
+	hash.Write(request[4:radiusHeaderLen])
+	hash.Write(response[radiusHeaderLen:])
+	hash.Write([]byte(s.secret))
+	copy(response[4:radiusHeaderLen], hash.Sum(nil))
+	return response
+}
+
+func (s *fakeRADIUSServer) Close() {
+	s.conn.Close()
+}
+
+// radiusRevealPassword decrypts a User-Password hidden by
+// radiusHidePassword.
+func radiusRevealPassword(hidden []byte, secret string, authenticator []byte) string {
+	data := make([]byte, len(hidden))
+	previous := authenticator
+	for i := 0; i+16 <= len(hidden); i += 16 {
+		digest := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			data[i+j] = hidden[i+j] ^ digest[j]
+		}
+		previous = hidden[i : i+16]
+	}
+	for len(data) > 0 && data[len(data)-1] == 0 {
+		data = data[:len(data)-1]
+	}
+	return string(data)
+}
+
+func TestRADIUSAuthenticator(t *testing.T) {
+	challenge := []byte("0123456789abcdef")
+	pap := func(password string) *Credential {
+		return &Credential{Protocol: PPPTypePasswordAuthentication, Username: "alice", Password: password}
+	}
+	chap := func(password string) *Credential {
+		return &Credential{
+			Protocol:   PPPTypeChallengeAuthentication,
+			Algorithm:  ChallengeAlgorithmMD5,
+			Username:   "alice",
+			Identifier: 7,
+			Challenge:  challenge,
+			Response:   chapMD5Response(7, password, challenge),
+		}
+	}
+	tests := []struct {
+		name       string
+		credential *Credential
+		// secret signs the responses of the server and drop is the number
+		// of requests it ignores.
+		secret       string
+		drop         int32
+		wantErr      bool
+		wantAccept   bool
+		wantMessage  string
+		wantFramedIP net.IP
+		wantRequests int32
+	}{
+		{
+			name:         "PAP accept",
+			credential:   pap("wonderland"),
+			secret:       testRADIUSSecret,
+			wantAccept:   true,
+			wantFramedIP: net.IPv4(10, 99, 0, 7),
+			wantRequests: 1,
+		},
+		{
+			name:         "PAP reject",
+			credential:   pap("looking-glass"),
+			secret:       testRADIUSSecret,
+			wantMessage:  "bad password",
+			wantRequests: 1,
+		},
+		{
+			name:         "CHAP accept",
+			credential:   chap("wonderland"),
+			secret:       testRADIUSSecret,
+			wantAccept:   true,
+			wantFramedIP: net.IPv4(10, 99, 0, 7),
+			wantRequests: 1,
+		},
+		{
+			name:         "CHAP reject",
+			credential:   chap("looking-glass"),
+			secret:       testRADIUSSecret,
+			wantMessage:  "bad password",
+			wantRequests: 1,
+		},
+		{
+			name:         "bad authenticator",
+			credential:   pap("wonderland"),
+			secret:       "wrong secret",
+			wantErr:      true,
+			wantRequests: 3,
+		},
+		{
+			name:         "retry after timeout",
+			credential:   pap("wonderland"),
+			secret:       testRADIUSSecret,
+			drop:         2,
+			wantAccept:   true,
+			wantFramedIP: net.IPv4(10, 99, 0, 7),
+			wantRequests: 3,
+		},
+		{
+			name:         "no response",
+			credential:   pap("wonderland"),
+			secret:       testRADIUSSecret,
+			drop:         3,
+			wantErr:      true,
+			wantRequests: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeRADIUSServer(t, &fakeRADIUSServer{
+				username: "alice",
+				password: "wonderland",
+				secret:   test.secret,
+				drop:     test.drop,
+				framedIP: net.IPv4(10, 99, 0, 7),
+			})
+			defer server.Close()
+			authenticator := &RADIUSAuthenticator{
+				Address: server.conn.LocalAddr().String(),
+				Secret:  testRADIUSSecret,
+				Timeout: 50 * time.Millisecond,
+				Retries: 3,
+			}
+			response, err := authenticator.Authenticate(context.Background(), &AuthRequest{
+				Credential: test.credential,
+				Peer:       net.HardwareAddr{2, 0, 0, 0, 0, 2},
+				SessionID:  1,
+			})
+			if requests := atomic.LoadInt32(&server.requests); requests != test.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests, test.wantRequests)
+			}
+			if test.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate = %+v, want an error", response)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if response.Accept != test.wantAccept {
+				t.Errorf("Accept = %v, want %v", response.Accept, test.wantAccept)
+			}
+			if response.Message != test.wantMessage {
+				t.Errorf("Message = %q, want %q", response.Message, test.wantMessage)
+			}
+			if !response.FramedAddress.Equal(test.wantFramedIP) {
+				t.Errorf("FramedAddress = %v, want %v", response.FramedAddress, test.wantFramedIP)
+			}
+		})
+	}
+}
+
+func TestRADIUSNASIdentifier(t *testing.T) {
+	tests := []struct {
+		name          string
+		nasIdentifier string
+		acName        string
+		want          string
+	}{
+		{"configured", "nas1", "ac1", "nas1"},
+		{"AC-Name", "", "ac1", "ac1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newFakeRADIUSServer(t, &fakeRADIUSServer{
+				username:       "alice",
+				password:       "wonderland",
+				secret:         testRADIUSSecret,
+				nasIdentifiers: make(chan string, 1),
+			})
+			defer server.Close()
+			authenticator := &RADIUSAuthenticator{
+				Address:       server.conn.LocalAddr().String(),
+				Secret:        testRADIUSSecret,
+				NASIdentifier: test.nasIdentifier,
+			}
+			_, err := authenticator.Authenticate(context.Background(), &AuthRequest{
+				Credential: &Credential{Protocol: PPPTypePasswordAuthentication, Username: "alice", Password: "wonderland"},
+				ACName:     test.acName,
+			})
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if got := <-server.nasIdentifiers; got != test.want {
+				t.Errorf("NAS-Identifier = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRADIUSAuthenticatorCancel(t *testing.T) {
+	server := newFakeRADIUSServer(t, &fakeRADIUSServer{secret: testRADIUSSecret, drop: 100})
+	defer server.Close()
+	authenticator := &RADIUSAuthenticator{
+		Address: server.conn.LocalAddr().String(),
+		Secret:  testRADIUSSecret,
+		Timeout: time.Minute,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := authenticator.Authenticate(ctx, &AuthRequest{
+		Credential: &Credential{Protocol: PPPTypePasswordAuthentication, Username: "alice"},
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Authenticate = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRADIUSHidePassword(t *testing.T) {
+	authenticator := []byte("0123456789abcdef")
+	tests := []struct {
+		name     string
+		password string
+		wantLen  int
+	}{
+		{"empty", "", 16},
+		{"short", "secret", 16},
+		{"block", "0123456789abcdef", 16},
+		{"two blocks", "0123456789abcdefg", 32},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hidden := radiusHidePassword(test.password, testRADIUSSecret, authenticator)
+			if len(hidden) != test.wantLen {
+				t.Errorf("hidden password of %d bytes, want %d", len(hidden), test.wantLen)
+			}
+			if got := radiusRevealPassword(hidden, testRADIUSSecret, authenticator); got != test.password {
+				t.Errorf("revealed %q, want %q", got, test.password)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package pppoe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// maxPendingFrames and pendingFrameLifetime bound the discovery frames kept
+// for a peer until its session is created.
+const (
+	maxPendingFrames     = 16
+	pendingFrameLifetime = 30 * time.Second
+)
+
+// frameWriter writes frames to a pcap file, or to a pcapng file if its name
+// ends in .pcapng.
+type frameWriter struct {
+	file *os.File
+	pcap *pcapgo.Writer
+	ng   *pcapgo.NgWriter
+}
+
+func createFrameWriter(path string) (*frameWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &frameWriter{file: file}
+	if strings.EqualFold(filepath.Ext(path), ".pcapng") {
+		w.ng, err = pcapgo.NewNgWriter(file, layers.LinkTypeEthernet)
+	} else {
+		w.pcap = pcapgo.NewWriter(file)
+		err = w.pcap.WriteFileHeader(uint32(snapshotLen), layers.LinkTypeEthernet)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *frameWriter) write(frame []byte, t time.Time) error {
+	ci := gopacket.CaptureInfo{Timestamp: t, CaptureLength: len(frame), Length: len(frame)}
+	if w.ng != nil {
+		if err := w.ng.WritePacket(ci, frame); err != nil {
+			return err
+		}
+		return w.ng.Flush()
+	}
+	return w.pcap.WritePacket(ci, frame)
+}
+
+func (w *frameWriter) close() error {
+	if w.ng != nil {
+		w.ng.Flush()
+	}
+	return w.file.Close()
+}
+
+type pendingFrame struct {
+	frame []byte
+	time  time.Time
+}
+
+// recorder writes the frames received and sent by a server either to one
+// file per run or to one file per session.
+type recorder struct {
+	path       string
+	perSession bool
+	run        *frameWriter
+	sessions   map[sessionKey]*frameWriter
+	pending    map[string][]pendingFrame
+}
+
+func newRecorder(path string, perSession bool) (*recorder, error) {
+	r := &recorder{
+		path:       path,
+		perSession: perSession,
+		sessions:   make(map[sessionKey]*frameWriter),
+		pending:    make(map[string][]pendingFrame),
+	}
+	if !perSession {
+		run, err := createFrameWriter(path)
+		if err != nil {
+			return nil, err
+		}
+		r.run = run
+	}
+	return r, nil
+}
+
+// sessionPath inserts the peer and session ID before the extension of the
+// configured path, e.g. dial.pcap becomes dial-020000000002-7.pcap.
+func (r *recorder) sessionPath(key sessionKey) string {
+	ext := filepath.Ext(r.path)
+	peer := strings.Replace(key.peer, ":", "", -1)
+	return fmt.Sprintf("%s-%s-%d%s", strings.TrimSuffix(r.path, ext), peer, key.id, ext)
+}
+
+// record writes a frame exchanged with peer. In per-session mode, discovery
+// frames are held back until the session they lead to is known.
+// Frames other than PPPoE are not recorded.
+func (r *recorder) record(frame []byte, peer net.HardwareAddr, sessions *sessionTable) error {
+	// Ethernet header (14 bytes), then PPPoE version/type, code and session ID.
+	if len(frame) < 20 {
+		return nil
+	}
+	ethernetType := layers.EthernetType(binary.BigEndian.Uint16(frame[12:14]))
+	if ethernetType != layers.EthernetTypePPPoEDiscovery && ethernetType != layers.EthernetTypePPPoESession {
+		return nil
+	}
+	now := time.Now()
+	if !r.perSession {
+		return r.run.write(frame, now)
+	}
+	code := layers.PPPoECode(frame[15])
+	sid := binary.BigEndian.Uint16(frame[16:18])
+	if code == layers.PPPoECodePADI || code == layers.PPPoECodePADO || code == layers.PPPoECodePADR || sessions.get(peer, sid) == nil {
+		pending := append(r.pending[peer.String()], pendingFrame{append([]byte(nil), frame...), now})
+		if len(pending) > maxPendingFrames {
+			pending = pending[len(pending)-maxPendingFrames:]
+		}
+		r.pending[peer.String()] = pending
+		return nil
+	}
+	key := sessionKey{peer.String(), sid}
+	w, ok := r.sessions[key]
+	if !ok {
+		var err error
+		if w, err = createFrameWriter(r.sessionPath(key)); err != nil {
+			return err
+		}
+		r.sessions[key] = w
+		for _, p := range r.pending[key.peer] {
+			w.write(p.frame, p.time)
+		}
+		delete(r.pending, key.peer)
+	}
+	return w.write(frame, now)
+}
+
+// closeSession closes the file of a session that has ended.
+func (r *recorder) closeSession(s *session) {
+	key := sessionKey{s.peer.String(), s.id}
+	if w, ok := r.sessions[key]; ok {
+		w.close()
+		delete(r.sessions, key)
+	}
+}
+
+// expire drops the discovery frames of peers that did not open a session.
+func (r *recorder) expire(before time.Time) {
+	for peer, pending := range r.pending {
+		if pending[len(pending)-1].time.Before(before) {
+			delete(r.pending, peer)
+		}
+	}
+}
+
+func (r *recorder) close() {
+	if r.run != nil {
+		r.run.close()
+	}
+	for key, w := range r.sessions {
+		w.close()
+		delete(r.sessions, key)
+	}
+}
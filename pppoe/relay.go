@@ -0,0 +1,495 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+)
+
+// relayClientLifetime is how long the discovery state of a client without
+// a session is kept.
+const relayClientLifetime = time.Minute
+
+// DefaultRelayIdleTimeout is how long a relayed session may carry no frame
+// before it is terminated on both sides. Peers sending LCP Echo-Requests
+// keep their session alive.
+const DefaultRelayIdleTimeout = 5 * time.Minute
+
+// authProtocolTraceNames maps authentication protocols to their name in the
+// trace.
+var authProtocolTraceNames = map[layers.PPPType]string{
+	PPPTypePasswordAuthentication:  "PPP PAP",
+	PPPTypeChallengeAuthentication: "PPP CHAP",
+	PPPTypeEAP:                     "PPP EAP",
+}
+
+// RelayConfig holds the settings of a Relay.
+type RelayConfig struct {
+	// Downstream faces the clients and Upstream the access concentrator.
+	Downstream *Interface
+	Upstream   *Interface
+	// DownstreamTransport and UpstreamTransport carry the frames of each
+	// side. If nil, the interfaces are opened with Backend.
+	DownstreamTransport Transport
+	UpstreamTransport   Transport
+	Backend             string
+	// IdleTimeout bounds the time without traffic on a session,
+	// DefaultRelayIdleTimeout if zero.
+	IdleTimeout time.Duration
+
+	// RecordFile is the pcap (or .pcapng) file receiving every PPPoE frame
+	// received and sent on either side.
+	RecordFile string
+	// OnCredential, if set, is called from the relay goroutine for every
+	// captured credential.
+	OnCredential func(credential *Credential)
+	// LogOutput receives the events of the relay, discarded if nil, in the
+	// format selected by LogFormat.
+	LogOutput io.Writer
+	LogFormat string
+}
+
+// Relay forwards the discovery and session traffic of the clients on one
+// interface to the access concentrators on another, like a PPPoE
+// intermediate agent, and captures the credentials sent through it. The
+// clients keep their connectivity: every frame is forwarded, with the MAC
+// addresses of the relay on each side and a session ID of its own toward
+// the clients.
+type Relay struct {
+	config   RelayConfig
+	down     Transport
+	up       Transport
+	downMAC  net.HardwareAddr
+	upMAC    net.HardwareAddr
+	recorder *recorder
+
+	clients    map[string]*relayClient
+	sessions   map[sessionKey]*relaySession
+	upSessions map[sessionKey]*relaySession
+	ids        *sessionIDs
+	decoder    *captureDecoder
+
+	mu          sync.Mutex
+	credentials []*Credential
+	events      chan Event
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// relayClient is the discovery state of a client, found by the
+// Relay-Session-Id of the replies.
+type relayClient struct {
+	mac     net.HardwareAddr
+	relayID []byte
+	// added tells whether the relay added the Relay-Session-Id, which is
+	// then removed from the replies.
+	added bool
+	// acs maps the AC-Cookie of each offer to the concentrator sending it,
+	// so that a PADR reaches the concentrator the client chose.
+	acs    map[string]net.HardwareAddr
+	lastAC net.HardwareAddr
+	seen   time.Time
+}
+
+// relaySession pairs the session ID given by the concentrator with the one
+// given to the client.
+type relaySession struct {
+	client net.HardwareAddr
+	downID uint16
+	ac     net.HardwareAddr
+	upID   uint16
+	seen   time.Time
+}
+
+func NewRelay(config RelayConfig) *Relay {
+	if config.LogOutput == nil {
+		config.LogOutput = ioutil.Discard
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = DefaultRelayIdleTimeout
+	}
+	r := &Relay{
+		config:     config,
+		clients:    make(map[string]*relayClient),
+		sessions:   make(map[sessionKey]*relaySession),
+		upSessions: make(map[sessionKey]*relaySession),
+		ids:        newSessionIDs(),
+		decoder:    newCaptureDecoder(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	r.decoder.onCredential = r.captured
+	return r
+}
+
+// Start opens both interfaces and relays in the background until Stop is
+// called.
+func (r *Relay) Start() error {
+	if r.config.Downstream == nil || r.config.Upstream == nil {
+		return errors.New("pppoe: relay needs a downstream and an upstream interface")
+	}
+	r.downMAC = r.config.Downstream.HardwareAddr
+	r.upMAC = r.config.Upstream.HardwareAddr
+	if r.config.RecordFile != "" {
+		recorder, err := newRecorder(r.config.RecordFile, false)
+		if err != nil {
+			return err
+		}
+		r.recorder = recorder
+	}
+	r.down = r.config.DownstreamTransport
+	r.up = r.config.UpstreamTransport
+	var err error
+	if r.down == nil {
+		r.down, err = OpenTransport(r.config.Backend, r.config.Downstream.Name)
+	}
+	if err == nil && r.up == nil {
+		r.up, err = OpenTransport(r.config.Backend, r.config.Upstream.Name)
+	}
+	if err != nil {
+		if r.down != nil {
+			r.down.Close()
+		}
+		if r.recorder != nil {
+			r.recorder.close()
+		}
+		return err
+	}
+	go r.serve(readFrames(r.down, r.done), readFrames(r.up, r.done))
+	return nil
+}
+
+// Serve starts the relay and returns a channel receiving its events, like
+// Server.Serve.
+func (r *Relay) Serve(ctx context.Context) (<-chan Event, error) {
+	events := make(chan Event, eventBufferSize)
+	r.events = events
+	if err := r.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.Stop()
+		case <-r.done:
+		}
+		close(events)
+	}()
+	return events, nil
+}
+
+// Stop terminates all relayed sessions and waits for the relay to shut
+// down.
+func (r *Relay) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+}
+
+// Wait blocks until the relay has shut down.
+func (r *Relay) Wait() {
+	<-r.done
+}
+
+// Credentials returns the credentials captured so far.
+func (r *Relay) Credentials() []*Credential {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Credential(nil), r.credentials...)
+}
+
+func (r *Relay) serve(downPackets, upPackets chan gopacket.Packet) {
+	defer close(r.done)
+	defer r.down.Close()
+	defer r.up.Close()
+	if r.recorder != nil {
+		defer r.recorder.close()
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			for _, sess := range r.sessions {
+				r.terminate(sess)
+			}
+			return
+		case now := <-ticker.C:
+			for id, client := range r.clients {
+				if now.Sub(client.seen) > relayClientLifetime {
+					delete(r.clients, id)
+				}
+			}
+			for _, sess := range r.sessions {
+				if now.Sub(sess.seen) > r.config.IdleTimeout {
+					r.emit(Event{Type: EventError, Peer: sess.client, SessionID: sess.downID, Protocol: "PPPoED", Message: fmt.Sprintf("session %d idle for %s", sess.downID, r.config.IdleTimeout)})
+					r.terminate(sess)
+					r.removeSession(sess)
+				}
+			}
+		case packet, ok := <-downPackets:
+			if !ok {
+				return
+			}
+			r.handleDownstream(packet)
+		case packet, ok := <-upPackets:
+			if !ok {
+				return
+			}
+			r.handleUpstream(packet)
+		}
+	}
+}
+
+func (r *Relay) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Local == nil {
+		e.Local = r.downMAC
+	}
+	if e.Type == "" {
+		e.Type = eventTypes[e.Protocol]
+	}
+	if r.events != nil {
+		select {
+		case r.events <- e:
+		case <-r.stop:
+		}
+	}
+	writeEvent(r.config.LogOutput, r.config.LogFormat, &e)
+}
+
+func (r *Relay) captured(session *CapturedSession, credential *Credential) {
+	r.mu.Lock()
+	r.credentials = append(r.credentials, credential)
+	r.mu.Unlock()
+	r.emit(Event{
+		Direction:  EventIncoming,
+		Peer:       session.Client,
+		SessionID:  session.ID,
+		Protocol:   authProtocolTraceNames[credential.Protocol],
+		Message:    credential.String(),
+		Fields:     credentialFields(credential),
+		Credential: credential,
+	})
+	if r.config.OnCredential != nil {
+		r.config.OnCredential(credential)
+	}
+}
+
+func (r *Relay) record(frame []byte) {
+	if r.recorder == nil || len(frame) < 12 {
+		return
+	}
+	if err := r.recorder.record(frame, net.HardwareAddr(frame[6:12]), nil); err != nil {
+		r.emit(Event{Type: EventError, Message: err.Error()})
+	}
+}
+
+func (r *Relay) sendDown(frame []byte) {
+	r.record(frame)
+	r.down.WriteFrame(frame)
+}
+
+func (r *Relay) sendUp(frame []byte) {
+	r.record(frame)
+	r.up.WriteFrame(frame)
+}
+
+// pppoeLayers returns the Ethernet and PPPoE layers of a packet sent by
+// another host to mac or, if broadcast is set, to everyone.
+func pppoeLayers(packet gopacket.Packet, mac net.HardwareAddr, broadcast bool) (*layers.Ethernet, *layers.PPPoE) {
+	ethernetLayer := packet.Layer(layers.LayerTypeEthernet)
+	pppoeLayer := packet.Layer(layers.LayerTypePPPoE)
+	if ethernetLayer == nil || pppoeLayer == nil {
+		return nil, nil
+	}
+	ethernet := ethernetLayer.(*layers.Ethernet)
+	if bytes.Equal(ethernet.SrcMAC, mac) {
+		return nil, nil
+	}
+	if !bytes.Equal(ethernet.DstMAC, mac) && !(broadcast && bytes.Equal(ethernet.DstMAC, broadcastMAC)) {
+		return nil, nil
+	}
+	return ethernet, pppoeLayer.(*layers.PPPoE)
+}
+
+// client returns the discovery state of the client sending a request,
+// creating it on its first PADI.
+func (r *Relay) client(mac net.HardwareAddr, tags *DiscoveryTags) *relayClient {
+	relayID := tags.RelaySessionID
+	if relayID == nil {
+		// The MAC address identifies the client as long as no other relay
+		// sits in front of us.
+		relayID = []byte(mac)
+	}
+	client, ok := r.clients[string(relayID)]
+	if !ok {
+		client = &relayClient{
+			mac:     mac,
+			relayID: relayID,
+			added:   tags.RelaySessionID == nil,
+			acs:     make(map[string]net.HardwareAddr),
+		}
+		r.clients[string(relayID)] = client
+	}
+	client.seen = time.Now()
+	return client
+}
+
+// request returns the payload of a client request as forwarded upstream,
+// with the Relay-Session-Id of the client.
+func (c *relayClient) request(payload []byte) []byte {
+	tags := ParsePPPoETags(payload)
+	if c.added {
+		tags = append(tags, PPPoETag{TagNameRelaySessionID, c.relayID})
+	}
+	return encodeTags(tags)
+}
+
+// reply returns the payload of a concentrator reply as forwarded to the
+// client, without the Relay-Session-Id added by the relay.
+func (c *relayClient) reply(payload []byte) []byte {
+	if !c.added {
+		return payload
+	}
+	tags := make([]PPPoETag, 0)
+	for _, tag := range ParsePPPoETags(payload) {
+		if tag.TagName != TagNameRelaySessionID {
+			tags = append(tags, tag)
+		}
+	}
+	return encodeTags(tags)
+}
+
+func (r *Relay) handleDownstream(packet gopacket.Packet) {
+	ethernet, pppoe := pppoeLayers(packet, r.downMAC, true)
+	if pppoe == nil {
+		return
+	}
+	r.record(packet.Data())
+	src := ethernet.SrcMAC
+	switch pppoe.Code {
+	case layers.PPPoECodePADI:
+		tags := DecodeDiscoveryTags(pppoe.Payload)
+		r.emit(Event{Direction: EventIncoming, Peer: src, Protocol: "PPPoED", Message: "Active Discovery Initiation (PADI)", Fields: tags.fields()})
+		client := r.client(src, tags)
+		payload := client.request(pppoe.Payload)
+		r.sendUp(buildFrame(r.upMAC, broadcastMAC, payload, layers.PPPoECodePADI, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(payload))))
+	case layers.PPPoECodePADR:
+		tags := DecodeDiscoveryTags(pppoe.Payload)
+		r.emit(Event{Direction: EventIncoming, Peer: src, Protocol: "PPPoED", Message: "Active Discovery Request (PADR)", Fields: tags.fields()})
+		client := r.client(src, tags)
+		ac, ok := client.acs[string(tags.ACCookie)]
+		if !ok {
+			ac = client.lastAC
+		}
+		if ac == nil {
+			r.emit(Event{Type: EventError, Direction: EventIncoming, Peer: src, Protocol: "PPPoED", Message: fmt.Sprintf("dropped PADR from %s without a PADO", src)})
+			return
+		}
+		payload := client.request(pppoe.Payload)
+		r.sendUp(buildFrame(r.upMAC, ac, payload, layers.PPPoECodePADR, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(payload))))
+	case layers.PPPoECodePADT:
+		sess, ok := r.sessions[sessionKey{src.String(), pppoe.SessionId}]
+		if !ok {
+			return
+		}
+		r.emit(Event{Type: EventTermination, Direction: EventIncoming, Peer: src, SessionID: sess.downID, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+		r.sendUp(buildFrame(r.upMAC, sess.ac, pppoe.Payload, layers.PPPoECodePADT, sess.upID, layers.EthernetTypePPPoEDiscovery, uint16(len(pppoe.Payload))))
+		r.removeSession(sess)
+	case layers.PPPoECodeSession:
+		sess, ok := r.sessions[sessionKey{src.String(), pppoe.SessionId}]
+		if !ok {
+			return
+		}
+		sess.seen = time.Now()
+		r.decoder.decode(packet)
+		r.sendUp(buildFrame(r.upMAC, sess.ac, pppoe.Payload, layers.PPPoECodeSession, sess.upID, layers.EthernetTypePPPoESession, uint16(len(pppoe.Payload))))
+	}
+}
+
+func (r *Relay) handleUpstream(packet gopacket.Packet) {
+	ethernet, pppoe := pppoeLayers(packet, r.upMAC, false)
+	if pppoe == nil {
+		return
+	}
+	r.record(packet.Data())
+	ac := ethernet.SrcMAC
+	switch pppoe.Code {
+	case layers.PPPoECodePADO, layers.PPPoECodePADS:
+		tags := DecodeDiscoveryTags(pppoe.Payload)
+		client, ok := r.clients[string(tags.RelaySessionID)]
+		if !ok {
+			return
+		}
+		payload := client.reply(pppoe.Payload)
+		if pppoe.Code == layers.PPPoECodePADO {
+			client.acs[string(tags.ACCookie)] = ac
+			client.lastAC = ac
+			r.sendDown(r.observe(buildFrame(r.downMAC, client.mac, payload, layers.PPPoECodePADO, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(payload)))))
+			r.emit(Event{Direction: EventOutgoing, Peer: client.mac, Protocol: "PPPoED", Message: fmt.Sprintf("Active Discovery Offer (PADO) from %s %s", ac, tags.ACName)})
+			return
+		}
+		downID := uint16(0)
+		if pppoe.SessionId != 0 {
+			if downID = r.ids.allocate(); downID == 0 {
+				r.emit(Event{Type: EventError, Peer: client.mac, Protocol: "PPPoED", Message: "no session ID available"})
+				return
+			}
+			sess := &relaySession{client: client.mac, downID: downID, ac: ac, upID: pppoe.SessionId, seen: time.Now()}
+			r.sessions[sessionKey{client.mac.String(), downID}] = sess
+			r.upSessions[sessionKey{ac.String(), pppoe.SessionId}] = sess
+		}
+		r.sendDown(r.observe(buildFrame(r.downMAC, client.mac, payload, layers.PPPoECodePADS, downID, layers.EthernetTypePPPoEDiscovery, uint16(len(payload)))))
+		r.emit(Event{Direction: EventOutgoing, Peer: client.mac, SessionID: downID, Protocol: "PPPoED", Message: fmt.Sprintf("Active Discovery Session-confirmation (PADS), session %d relayed to %s session %d", downID, ac, pppoe.SessionId)})
+	case layers.PPPoECodePADT:
+		sess, ok := r.upSessions[sessionKey{ac.String(), pppoe.SessionId}]
+		if !ok {
+			return
+		}
+		r.sendDown(buildFrame(r.downMAC, sess.client, pppoe.Payload, layers.PPPoECodePADT, sess.downID, layers.EthernetTypePPPoEDiscovery, uint16(len(pppoe.Payload))))
+		r.emit(Event{Type: EventTermination, Direction: EventOutgoing, Peer: sess.client, SessionID: sess.downID, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+		r.removeSession(sess)
+	case layers.PPPoECodeSession:
+		sess, ok := r.upSessions[sessionKey{ac.String(), pppoe.SessionId}]
+		if !ok {
+			return
+		}
+		sess.seen = time.Now()
+		r.sendDown(r.observe(buildFrame(r.downMAC, sess.client, pppoe.Payload, layers.PPPoECodeSession, sess.downID, layers.EthernetTypePPPoESession, uint16(len(pppoe.Payload)))))
+	}
+}
+
+// observe passes a frame sent to a client to the decoder, which sees both
+// directions of the downstream side, and returns it.
+func (r *Relay) observe(frame []byte) []byte {
+	r.decoder.decode(gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default))
+	return frame
+}
+
+// terminate sends a PADT to both ends of a relayed session.
+func (r *Relay) terminate(sess *relaySession) {
+	r.sendDown(buildFrame(r.downMAC, sess.client, nil, layers.PPPoECodePADT, sess.downID, layers.EthernetTypePPPoEDiscovery, 0))
+	r.sendUp(buildFrame(r.upMAC, sess.ac, nil, layers.PPPoECodePADT, sess.upID, layers.EthernetTypePPPoEDiscovery, 0))
+	r.emit(Event{Type: EventTermination, Direction: EventOutgoing, Peer: sess.client, SessionID: sess.downID, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+}
+
+func (r *Relay) removeSession(sess *relaySession) {
+	delete(r.sessions, sessionKey{sess.client.String(), sess.downID})
+	delete(r.upSessions, sessionKey{sess.ac.String(), sess.upID})
+	r.ids.release(sess.downID)
+	delete(r.decoder.states, pairKey(sess.client, r.downMAC, sess.downID))
+}
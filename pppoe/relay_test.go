@@ -0,0 +1,272 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/gopacket/layers"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	testServerMAC      = net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	testRelayUpMAC     = net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	testRelayDownMAC   = net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	testRelayClientMAC = net.HardwareAddr{2, 0, 0, 0, 0, 4}
+)
+
+// uncloseableTransport keeps a pipe open when the client or server on it
+// stops, as an interface stays up when a host on it goes away.
+type uncloseableTransport struct {
+	Transport
+}
+
+func (uncloseableTransport) Close() error {
+	return nil
+}
+
+// TestRelay dials a Server through a Relay over pipes, then hangs up from
+// either side and checks that the relay forgets the session.
+func TestRelay(t *testing.T) {
+	tests := []struct {
+		name string
+		auth AuthProtocol
+		// serverHangup terminates the session from the server rather than
+		// from the client.
+		serverHangup bool
+	}{
+		{"PAP client hangup", AuthPAP, false},
+		{"CHAP-MD5 client hangup", AuthCHAPMD5, false},
+		{"EAP client hangup", AuthEAP, false},
+		{"PAP server hangup", AuthPAP, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverEnd, upEnd := NewPipe()
+			downEnd, clientEnd := NewPipe()
+			server := NewServer(Config{
+				Interface:       &Interface{Name: "up0", HardwareAddr: testServerMAC},
+				Transport:       uncloseableTransport{serverEnd},
+				AuthPreference:  []AuthProtocol{test.auth},
+				SessionHoldTime: time.Minute,
+				LogOutput:       ioutil.Discard,
+			})
+			if err := server.Start(); err != nil {
+				t.Fatalf("server Start: %v", err)
+			}
+			defer server.Stop()
+			relay := NewRelay(RelayConfig{
+				Downstream:          &Interface{Name: "down0", HardwareAddr: testRelayDownMAC},
+				Upstream:            &Interface{Name: "up0", HardwareAddr: testRelayUpMAC},
+				DownstreamTransport: downEnd,
+				UpstreamTransport:   upEnd,
+				LogOutput:           ioutil.Discard,
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			events, err := relay.Serve(ctx)
+			if err != nil {
+				t.Fatalf("relay Serve: %v", err)
+			}
+			terminations := make(chan Event, eventBufferSize)
+			go func() {
+				for e := range events {
+					if e.Type == EventTermination {
+						terminations <- e
+					}
+				}
+			}()
+			client := NewClient(ClientConfig{
+				Transport:    uncloseableTransport{clientEnd},
+				HardwareAddr: testRelayClientMAC,
+				Username:     "user",
+				Password:     "secret",
+				RestartTimer: 100 * time.Millisecond,
+				LogOutput:    ioutil.Discard,
+			})
+			defer client.Close()
+			dialCtx, dialCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer dialCancel()
+			session, err := client.Connect(dialCtx)
+			if err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			if !bytes.Equal(session.AC, testRelayDownMAC) {
+				t.Errorf("client session with %s, want the relay %s", session.AC, testRelayDownMAC)
+			}
+			credentials := relay.Credentials()
+			if len(credentials) != 1 || credentials[0].Username != "user" || credentials[0].Protocol != test.auth.Type {
+				t.Errorf("relay captured %v, want a %s credential of user", credentials, test.auth)
+			}
+
+			if test.serverHangup {
+				server.Stop()
+				if err := client.Wait(); err == nil {
+					t.Error("client not told of the server hangup")
+				}
+			} else {
+				client.Close()
+			}
+			select {
+			case <-terminations:
+			case <-time.After(5 * time.Second):
+				t.Fatal("PADT not relayed")
+			}
+			cancel()
+			relay.Wait()
+			if len(relay.sessions) != 0 || len(relay.upSessions) != 0 {
+				t.Errorf("relay kept %d downstream and %d upstream sessions after PADT", len(relay.sessions), len(relay.upSessions))
+			}
+		})
+	}
+}
+
+// TestRelayDiscovery runs the discovery stage through a Relay to a Server
+// over pipes and checks that the client only ever sees the relay.
+func TestRelayDiscovery(t *testing.T) {
+	serverEnd, upEnd := NewPipe()
+	downEnd, clientEnd := NewPipe()
+	server := NewServer(Config{
+		Interface: &Interface{Name: "up0", HardwareAddr: testServerMAC},
+		Transport: serverEnd,
+		LogOutput: ioutil.Discard,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("server Start: %v", err)
+	}
+	defer server.Stop()
+	relay := NewRelay(RelayConfig{
+		Downstream:          &Interface{Name: "down0", HardwareAddr: testRelayDownMAC},
+		Upstream:            &Interface{Name: "up0", HardwareAddr: testRelayUpMAC},
+		DownstreamTransport: downEnd,
+		UpstreamTransport:   upEnd,
+		LogOutput:           ioutil.Discard,
+	})
+	if err := relay.Start(); err != nil {
+		t.Fatalf("relay Start: %v", err)
+	}
+	defer relay.Stop()
+	peer := &pipePeer{t: t, transport: clientEnd, mac: testRelayClientMAC}
+
+	peer.send(broadcastMAC, layers.PPPoECodePADI, 0, PPPoETags(nil))
+	ethernet, pppoe := peer.receive()
+	tags := DecodeDiscoveryTags(pppoe.Payload)
+	if pppoe.Code != layers.PPPoECodePADO || !bytes.Equal(ethernet.SrcMAC, testRelayDownMAC) {
+		t.Fatalf("received %s from %s, want a PADO from %s", pppoe.Code, ethernet.SrcMAC, testRelayDownMAC)
+	}
+	if tags.RelaySessionID != nil {
+		t.Errorf("PADO carries the Relay-Session-Id %x added by the relay", tags.RelaySessionID)
+	}
+
+	peer.send(testRelayDownMAC, layers.PPPoECodePADR, 0, PPPoETags([]PPPoETag{{TagName: TagNameACCookie, TagValue: tags.ACCookie}}))
+	_, pppoe = peer.receive()
+	if pppoe.Code != layers.PPPoECodePADS || pppoe.SessionId == 0 {
+		t.Fatalf("received %s for session %d, want a PADS", pppoe.Code, pppoe.SessionId)
+	}
+	sid := pppoe.SessionId
+
+	ethernet, pppoe = peer.receive()
+	if pppoe.Code != layers.PPPoECodeSession || pppoe.SessionId != sid || !bytes.Equal(ethernet.SrcMAC, testRelayDownMAC) {
+		t.Fatalf("received %s for session %d from %s, want session %d from %s", pppoe.Code, pppoe.SessionId, ethernet.SrcMAC, sid, testRelayDownMAC)
+	}
+}
+
+// TestRelayClientTags checks how the Relay-Session-Id is added to the
+// requests of a client and removed from the replies.
+func TestRelayClientTags(t *testing.T) {
+	serviceName := PPPoETag{TagName: TagNameServiceName, TagValue: []byte("isp")}
+	relayID := PPPoETag{TagName: TagNameRelaySessionID, TagValue: []byte{9, 9}}
+	tests := []struct {
+		name   string
+		client *relayClient
+		tags   []PPPoETag
+		// request and reply are the tags forwarded each way.
+		request []PPPoETag
+		reply   []PPPoETag
+	}{
+		{
+			name:    "added",
+			client:  &relayClient{relayID: []byte{1, 2}, added: true},
+			tags:    []PPPoETag{serviceName},
+			request: []PPPoETag{serviceName, {TagName: TagNameRelaySessionID, TagValue: []byte{1, 2}}},
+			reply:   []PPPoETag{serviceName},
+		},
+		{
+			name:    "kept",
+			client:  &relayClient{relayID: []byte{9, 9}},
+			tags:    []PPPoETag{serviceName, relayID},
+			request: []PPPoETag{serviceName, relayID},
+			reply:   []PPPoETag{serviceName, relayID},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := encodeTags(test.tags)
+			if got, want := test.client.request(payload), encodeTags(test.request); !bytes.Equal(got, want) {
+				t.Errorf("request = %x, want %x", got, want)
+			}
+			if got, want := test.client.reply(encodeTags(test.request)), encodeTags(test.reply); !bytes.Equal(got, want) {
+				t.Errorf("reply = %x, want %x", got, want)
+			}
+		})
+	}
+}
+
+// TestRelayIdleTimeout checks that a session without traffic is
+// terminated on both sides and forgotten.
+func TestRelayIdleTimeout(t *testing.T) {
+	serverEnd, upEnd := NewPipe()
+	downEnd, clientEnd := NewPipe()
+	server := NewServer(Config{
+		Interface:       &Interface{Name: "up0", HardwareAddr: testServerMAC},
+		Transport:       uncloseableTransport{serverEnd},
+		SessionHoldTime: time.Minute,
+		LogOutput:       ioutil.Discard,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("server Start: %v", err)
+	}
+	defer server.Stop()
+	relay := NewRelay(RelayConfig{
+		Downstream:          &Interface{Name: "down0", HardwareAddr: testRelayDownMAC},
+		Upstream:            &Interface{Name: "up0", HardwareAddr: testRelayUpMAC},
+		DownstreamTransport: downEnd,
+		UpstreamTransport:   upEnd,
+		IdleTimeout:         100 * time.Millisecond,
+		LogOutput:           ioutil.Discard,
+	})
+	if err := relay.Start(); err != nil {
+		t.Fatalf("relay Start: %v", err)
+	}
+	client := NewClient(ClientConfig{
+		Transport:    uncloseableTransport{clientEnd},
+		HardwareAddr: testRelayClientMAC,
+		Username:     "user",
+		Password:     "secret",
+		LogOutput:    ioutil.Discard,
+	})
+	defer client.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Connect(ctx); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	ended := make(chan error, 1)
+	go func() {
+		ended <- client.Wait()
+	}()
+	select {
+	case err := <-ended:
+		if err != ErrLinkTerminated {
+			t.Errorf("client ended with %v, want %v", err, ErrLinkTerminated)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session not terminated")
+	}
+	relay.Stop()
+	if len(relay.sessions) != 0 || len(relay.upSessions) != 0 {
+		t.Errorf("relay kept %d downstream and %d upstream sessions after the idle timeout", len(relay.sessions), len(relay.upSessions))
+	}
+}
@@ -0,0 +1,114 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"time"
+)
+
+// DefaultScanWindow is how long Scan collects PADO replies.
+const DefaultScanWindow = 3 * time.Second
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// ScanConfig holds the settings of Scan. Zero values are replaced by the
+// package defaults.
+type ScanConfig struct {
+	Interface *Interface
+	// Transport carries the frames of the scan. If nil, the interface is
+	// opened with Backend.
+	Transport Transport
+	Backend   string
+	// ServiceName is requested in the PADI, any service if empty.
+	ServiceName string
+	Window      time.Duration
+}
+
+// Offer is a PADO received in reply to the PADI of Scan.
+type Offer struct {
+	Time time.Time
+	AC   net.HardwareAddr
+	Tags *DiscoveryTags
+	// HostUniqEchoed tells whether the concentrator returned the Host-Uniq
+	// of the PADI as RFC 2516 requires.
+	HostUniqEchoed bool
+}
+
+// Scan broadcasts a PADI on the interface and returns every PADO received
+// until the window expires or ctx is cancelled, in order of arrival.
+func Scan(ctx context.Context, config ScanConfig) ([]*Offer, error) {
+	if config.Interface == nil {
+		return nil, errors.New("pppoe: no interface configured")
+	}
+	if config.Window == 0 {
+		config.Window = DefaultScanWindow
+	}
+	transport := config.Transport
+	if transport == nil {
+		var err error
+		if transport, err = OpenTransport(config.Backend, config.Interface.Name); err != nil {
+			return nil, err
+		}
+	}
+	defer transport.Close()
+
+	frames := make(chan []byte)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			frame, err := transport.ReadFrame()
+			if err != nil {
+				return
+			}
+			select {
+			case frames <- frame:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	mac := config.Interface.HardwareAddr
+	hostUniq := GenerateRandomBytes(8)
+	payload := PPPoETags([]PPPoETag{{TagNameServiceName, config.ServiceName}, {TagNameHostUniq, hostUniq}})
+	padi := buildFrame(mac, broadcastMAC, payload, layers.PPPoECodePADI, 0, layers.EthernetTypePPPoEDiscovery, uint16(len(payload)))
+	if err := transport.WriteFrame(padi); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(config.Window)
+	defer timer.Stop()
+	offers := make([]*Offer, 0)
+	for {
+		select {
+		case <-ctx.Done():
+			return offers, nil
+		case <-timer.C:
+			return offers, nil
+		case frame := <-frames:
+			packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+			ethernetLayer := packet.Layer(layers.LayerTypeEthernet)
+			pppoeLayer := packet.Layer(layers.LayerTypePPPoE)
+			if ethernetLayer == nil || pppoeLayer == nil {
+				continue
+			}
+			ethernet := ethernetLayer.(*layers.Ethernet)
+			pppoe := pppoeLayer.(*layers.PPPoE)
+			if pppoe.Code != layers.PPPoECodePADO || !bytes.Equal(ethernet.DstMAC, mac) {
+				continue
+			}
+			tags := DecodeDiscoveryTags(pppoe.Payload)
+			offers = append(offers, &Offer{
+				Time:           time.Now(),
+				AC:             ethernet.SrcMAC,
+				Tags:           tags,
+				HostUniqEchoed: bytes.Equal(tags.HostUniq, hostUniq),
+			})
+		}
+	}
+}
@@ -0,0 +1,512 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultSessionHoldTime is how long a session is kept up once its network
+// phase is reached before the simulator terminates it.
+const DefaultSessionHoldTime = 30 * time.Second
+
+// Config holds the settings of a Server. Zero values are replaced by the
+// package defaults.
+type Config struct {
+	Interface *Interface
+	// Transport carries the frames of the server. If nil, the interface is
+	// opened with Backend.
+	Transport Transport
+	Backend   string
+
+	ACName string
+	// ServiceNames are offered in the PADO, an empty Service-Name if none.
+	// ServicePolicy decides which requested Service-Names are served: a
+	// PADI asking for another one gets no PADO and a PADR gets a PADS with a
+	// Service-Name-Error. It defaults to ServiceAny without ServiceNames and
+	// to ServiceList otherwise.
+	ServiceNames  []string
+	ServicePolicy ServicePolicy
+	// IgnoreMACs are hosts whose frames the server never answers, such as
+	// another access concentrator on the segment.
+	IgnoreMACs []net.HardwareAddr
+	// MaxSessions limits the sessions served at once, 0 for no limit. A
+	// PADR beyond the limit gets a PADS with an AC-System-Error.
+	MaxSessions int
+	// CookieLength is the size of the AC-Cookie sent in the PADO, at most
+	// 32 bytes. The cookie is signed with a secret replaced every
+	// CookieLifetime, and a PADR without a valid cookie is dropped.
+	CookieLength   int
+	CookieLifetime time.Duration
+	MRU            uint16
+	AuthPreference []AuthProtocol
+	// Authenticator decides whether PAP, CHAP-MD5 and EAP-MD5 peers are
+	// accepted, all of them if nil. A refused peer gets a Nak or Failure
+	// carrying the message of the decision and its session is terminated.
+	Authenticator Authenticator
+	IPCP          *IPCPConfig
+
+	RestartTimer time.Duration
+	MaxConfigure int
+	MaxTerminate int
+	MaxFailure   int
+
+	SessionHoldTime time.Duration
+	// TerminateOnCapture terminates a session as soon as its credential has
+	// been captured instead of entering the network phase.
+	TerminateOnCapture bool
+
+	// RecordFile is the pcap (or .pcapng) file receiving every PPPoE frame
+	// received and sent. With RecordPerSession, each session is written to
+	// its own file named after RecordFile, the peer and the session ID.
+	RecordFile       string
+	RecordPerSession bool
+	// ExitOnCapture stops the server once a credential has been captured
+	// and all sessions have ended.
+	ExitOnCapture bool
+	// OnCredential, if set, is called from the server goroutine for every
+	// captured credential.
+	OnCredential func(credential *Credential)
+	// LogOutput receives the events of the server, discarded if nil, as a
+	// text trace or as newline-delimited JSON if LogFormat is LogFormatJSON.
+	LogOutput io.Writer
+	LogFormat string
+}
+
+func (c *Config) setDefaults() {
+	if c.ACName == "" {
+		c.ACName = defaultACName
+	}
+	if c.ServicePolicy == "" {
+		c.ServicePolicy = ServiceAny
+		if len(c.ServiceNames) > 0 {
+			c.ServicePolicy = ServiceList
+		}
+	}
+	if c.CookieLength == 0 {
+		c.CookieLength = defaultCookieLength
+	}
+	if c.CookieLifetime == 0 {
+		c.CookieLifetime = DefaultCookieLifetime
+	}
+	if c.MRU == 0 {
+		c.MRU = DefaultMRU
+	}
+	if len(c.AuthPreference) == 0 {
+		c.AuthPreference = DefaultAuthPreference
+	}
+	if c.IPCP == nil {
+		c.IPCP = NewDefaultIPCPConfig()
+	}
+	if c.RestartTimer == 0 {
+		c.RestartTimer = DefaultRestartTimer
+	}
+	if c.MaxConfigure == 0 {
+		c.MaxConfigure = DefaultMaxConfigure
+	}
+	if c.MaxTerminate == 0 {
+		c.MaxTerminate = DefaultMaxTerminate
+	}
+	if c.MaxFailure == 0 {
+		c.MaxFailure = DefaultMaxFailure
+	}
+	if c.SessionHoldTime == 0 {
+		c.SessionHoldTime = DefaultSessionHoldTime
+	}
+	if c.LogOutput == nil {
+		c.LogOutput = ioutil.Discard
+	}
+}
+
+// Server is a PPPoE access concentrator answering every client on one
+// interface, each in its own session.
+type Server struct {
+	config    Config
+	mac       net.HardwareAddr
+	transport Transport
+	recorder  *recorder
+	sessions  *sessionTable
+	cookies   *cookieJar
+	decisions chan authDecision
+
+	mu          sync.Mutex
+	credentials []*Credential
+	events      chan Event
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewServer(config Config) *Server {
+	config.setDefaults()
+	return &Server{
+		config:    config,
+		sessions:  newSessionTable(),
+		decisions: make(chan authDecision),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start opens the interface and serves clients in the background until Stop
+// is called.
+func (s *Server) Start() error {
+	if s.config.Interface == nil {
+		return errors.New("pppoe: no interface configured")
+	}
+	s.mac = s.config.Interface.HardwareAddr
+	cookies, err := newCookieJar(s.config.CookieLength, s.config.CookieLifetime)
+	if err != nil {
+		return err
+	}
+	s.cookies = cookies
+	if s.config.RecordFile != "" {
+		recorder, err := newRecorder(s.config.RecordFile, s.config.RecordPerSession)
+		if err != nil {
+			return err
+		}
+		s.recorder = recorder
+	}
+	s.transport = s.config.Transport
+	if s.transport == nil {
+		transport, err := OpenTransport(s.config.Backend, s.config.Interface.Name)
+		if err != nil {
+			if s.recorder != nil {
+				s.recorder.close()
+			}
+			return err
+		}
+		s.transport = transport
+	}
+	go s.serve(readFrames(s.transport, s.done))
+	return nil
+}
+
+// readFrames decodes the frames received by the transport until it is
+// closed or done is closed.
+func readFrames(transport Transport, done chan struct{}) chan gopacket.Packet {
+	packets := make(chan gopacket.Packet)
+	go func() {
+		defer close(packets)
+		for {
+			frame, err := transport.ReadFrame()
+			if err != nil {
+				return
+			}
+			select {
+			case packets <- gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return packets
+}
+
+// Serve starts the server and returns a channel receiving its events. The
+// server stops when ctx is cancelled, or once a credential is captured if
+// ExitOnCapture is set, and the channel is closed after it has shut down.
+// Captured credentials arrive as EventAuth events with Credential set.
+//
+// The channel must be drained: the server waits for the receiver before
+// handling the next packet. Events emitted while stopping may be dropped.
+func (s *Server) Serve(ctx context.Context) (<-chan Event, error) {
+	events := make(chan Event, eventBufferSize)
+	s.events = events
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.done:
+		}
+		close(events)
+	}()
+	return events, nil
+}
+
+// Serve runs a server with the given configuration until ctx is cancelled.
+// See Server.Serve.
+func Serve(ctx context.Context, config Config) (<-chan Event, error) {
+	return NewServer(config).Serve(ctx)
+}
+
+// Stop terminates all sessions and waits for the server to shut down.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// Wait blocks until the server has shut down.
+func (s *Server) Wait() {
+	<-s.done
+}
+
+// Credentials returns the credentials captured so far.
+func (s *Server) Credentials() []*Credential {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Credential(nil), s.credentials...)
+}
+
+func (s *Server) serve(packets chan gopacket.Packet) {
+	defer close(s.done)
+	defer s.transport.Close()
+	if s.recorder != nil {
+		defer s.recorder.close()
+	}
+	ticker := time.NewTicker(time.Second / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			for _, sess := range s.sessions.sessions {
+				if !sess.closed {
+					sess.terminate()
+				}
+			}
+			s.sweep()
+			return
+		case now := <-ticker.C:
+			for _, sess := range s.sessions.sessions {
+				sess.tick(now)
+			}
+			if s.recorder != nil {
+				s.recorder.expire(now.Add(-pendingFrameLifetime))
+			}
+		case d := <-s.decisions:
+			d.session.decided(d.auth, d.response, d.err)
+		case packet, ok := <-packets:
+			if !ok {
+				return
+			}
+			if data := packet.Data(); len(data) >= 12 {
+				s.record(data, net.HardwareAddr(data[6:12]))
+			}
+			s.handlePacket(packet)
+		}
+		s.sweep()
+		if s.config.ExitOnCapture && len(s.Credentials()) > 0 && s.sessions.len() == 0 {
+			return
+		}
+	}
+}
+
+// sweep removes the closed sessions.
+func (s *Server) sweep() {
+	for _, sess := range s.sessions.sessions {
+		if sess.closed {
+			s.sessions.remove(sess)
+			if s.recorder != nil {
+				s.recorder.closeSession(sess)
+			}
+		}
+	}
+}
+
+// authDecision is the answer of the Authenticator to the pending response
+// of a session.
+type authDecision struct {
+	session  *session
+	auth     *authSession
+	response *AuthResponse
+	err      error
+}
+
+// authenticate submits a credential of sess to the Authenticator. The
+// decision is applied by the server goroutine once it arrives.
+func (s *Server) authenticate(sess *session, credential *Credential) {
+	auth := sess.auth
+	auth.pending = true
+	request := &AuthRequest{Credential: credential, Peer: sess.peer, SessionID: sess.id, ACName: s.config.ACName}
+	go func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-s.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		response, err := s.config.Authenticator.Authenticate(ctx, request)
+		if err == nil && response == nil {
+			err = errors.New("no decision")
+		}
+		select {
+		case s.decisions <- authDecision{sess, auth, response, err}:
+		case <-s.done:
+		}
+	}()
+}
+
+func (s *Server) captured(credential *Credential) {
+	s.mu.Lock()
+	s.credentials = append(s.credentials, credential)
+	s.mu.Unlock()
+	if s.config.OnCredential != nil {
+		s.config.OnCredential(credential)
+	}
+}
+
+func (s *Server) handlePacket(packet gopacket.Packet) {
+	ethernetLayer := packet.Layer(layers.LayerTypeEthernet)
+	if ethernetLayer == nil {
+		return
+	}
+	ethernet, _ := ethernetLayer.(*layers.Ethernet)
+	for _, mac := range s.config.IgnoreMACs {
+		if bytes.Equal(ethernet.SrcMAC, mac) {
+			return
+		}
+	}
+	pppoeLayer := packet.Layer(layers.LayerTypePPPoE)
+	if pppoeLayer == nil {
+		return
+	}
+	pppoe, _ := pppoeLayer.(*layers.PPPoE)
+	switch pppoe.Code {
+	case layers.PPPoECodePADI:
+		request := DecodeDiscoveryTags(pppoe.Payload)
+		s.emit(Event{Direction: EventIncoming, Local: ethernet.DstMAC, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: "Active Discovery Initiation (PADI)", Fields: request.fields()})
+		service := request.ServiceName()
+		if !s.config.ServicePolicy.accepts(s.config.ServiceNames, service) {
+			s.emit(Event{Type: EventError, Direction: EventIncoming, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: fmt.Sprintf("ignored PADI from %s for unsupported service %q", ethernet.SrcMAC, service)})
+			return
+		}
+		tags := []PPPoETag{{TagNameACName, s.config.ACName}}
+		if service != "" && !containsString(s.config.ServiceNames, service) {
+			tags = append(tags, PPPoETag{TagNameServiceName, service})
+		}
+		for _, name := range s.config.ServiceNames {
+			tags = append(tags, PPPoETag{TagNameServiceName, name})
+		}
+		cookie, err := s.cookies.issue(ethernet.SrcMAC)
+		if err != nil {
+			s.emit(Event{Type: EventError, Direction: EventIncoming, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: fmt.Sprintf("no AC-Cookie for %s: %v", ethernet.SrcMAC, err)})
+			return
+		}
+		tags = append(tags, PPPoETag{TagNameACCookie, cookie})
+		s.sendPADO(ethernet.SrcMAC, append(tags, request.Echo()...))
+		s.logOutgoing(ethernet.SrcMAC, 0, "PPPoED", "Active Discovery Offer (PADO)")
+	case layers.PPPoECodePADR:
+		request := DecodeDiscoveryTags(pppoe.Payload)
+		s.emit(Event{Direction: EventIncoming, Local: ethernet.DstMAC, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: "Active Discovery Request (PADR)", Fields: request.fields()})
+		valid, err := s.cookies.verify(ethernet.SrcMAC, request.ACCookie)
+		if err != nil {
+			s.emit(Event{Type: EventError, Direction: EventIncoming, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: fmt.Sprintf("cannot verify the AC-Cookie of %s: %v", ethernet.SrcMAC, err)})
+			return
+		}
+		if !valid {
+			s.emit(Event{Type: EventError, Direction: EventIncoming, Peer: ethernet.SrcMAC, Protocol: "PPPoED", Message: fmt.Sprintf("dropped PADR from %s with invalid AC-Cookie", ethernet.SrcMAC)})
+			return
+		}
+		if !s.config.ServicePolicy.accepts(s.config.ServiceNames, request.ServiceName()) {
+			s.sendPADSError(ethernet.SrcMAC, request, TagNameServiceNameError, "Service not supported")
+			return
+		}
+		tags := []PPPoETag{{TagNameServiceName, request.ServiceName()}, {TagNameACName, s.config.ACName}}
+		if sess := s.sessions.unanswered(ethernet.SrcMAC, request.HostUniq); sess != nil {
+			// The PADS was lost: confirm the same session again.
+			s.sendPADS(ethernet.SrcMAC, sess.id, append(tags, request.Echo()...))
+			s.logOutgoing(ethernet.SrcMAC, sess.id, "PPPoED", fmt.Sprintf("Active Discovery Session-confirmation (PADS), session %d resent", sess.id))
+			return
+		}
+		if s.config.MaxSessions > 0 && s.sessions.len() >= s.config.MaxSessions {
+			s.sendPADSError(ethernet.SrcMAC, request, TagNameACSystemError, "Session limit reached")
+			return
+		}
+		sess := s.sessions.add(s, ethernet.SrcMAC, request.HostUniq)
+		if sess == nil {
+			s.sendPADSError(ethernet.SrcMAC, request, TagNameACSystemError, "No session ID available")
+			return
+		}
+		s.sendPADS(ethernet.SrcMAC, sess.id, append(tags, request.Echo()...))
+		s.logOutgoing(ethernet.SrcMAC, sess.id, "PPPoED", fmt.Sprintf("Active Discovery Session-confirmation (PADS), session %d", sess.id))
+		sess.start()
+	case layers.PPPoECodeSession:
+		sess := s.sessions.get(ethernet.SrcMAC, pppoe.SessionId)
+		if sess == nil || sess.closed {
+			return
+		}
+		pppLayer := packet.Layer(layers.LayerTypePPP)
+		if pppLayer != nil {
+			sess.handlePPP(pppLayer.(*layers.PPP))
+		}
+	case layers.PPPoECodePADT:
+		s.emit(Event{Type: EventTermination, Direction: EventIncoming, Local: ethernet.DstMAC, Peer: ethernet.SrcMAC, SessionID: pppoe.SessionId, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+		sess := s.sessions.get(ethernet.SrcMAC, pppoe.SessionId)
+		if sess == nil {
+			return
+		}
+		s.sendPADT(ethernet.SrcMAC, sess.id, pppoe.Payload)
+		s.emit(Event{Type: EventTermination, Direction: EventOutgoing, Peer: ethernet.SrcMAC, SessionID: sess.id, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+		sess.down()
+	}
+}
+
+// record writes a frame exchanged with peer to the recording, if any.
+func (s *Server) record(frame []byte, peer net.HardwareAddr) {
+	if s.recorder == nil {
+		return
+	}
+	if err := s.recorder.record(frame, peer, s.sessions); err != nil {
+		s.logError(err)
+	}
+}
+
+// newControlNegotiator returns an automaton for the control protocol of the
+// session sid with the peer dst, using the timers of the configuration.
+// Packets sent by the automaton are logged under name.
+func (s *Server) newControlNegotiator(handler negotiationHandler, protocol layers.PPPType, name string, dst net.HardwareAddr, sid uint16) *negotiator {
+	n := newNegotiator(handler, func(code PPPLCPCode, id byte, options []Option) {
+		s.sendControlProtocol(dst, protocol, code, sid, id, options)
+		s.logOutgoing(dst, sid, name, code)
+	})
+	n.RestartTimer = s.config.RestartTimer
+	n.MaxConfigure = s.config.MaxConfigure
+	n.MaxTerminate = s.config.MaxTerminate
+	n.MaxFailure = s.config.MaxFailure
+	return n
+}
+
+// ServePPPoE answers the clients on the interface with PAP and returns the
+// first username and password captured once all sessions have ended.
+func ServePPPoE(iface *Interface) (string, string, error) {
+	credentials, err := ServeCredentials(iface, []AuthProtocol{AuthPAP})
+	if err != nil || len(credentials) == 0 {
+		return "", "", err
+	}
+	return credentials[0].Username, credentials[0].Password, nil
+}
+
+// ServeCredentials answers every client on the interface, each in its own
+// session. It returns the captured credentials once at least one has been
+// captured and all sessions have ended.
+func ServeCredentials(iface *Interface, authPreference []AuthProtocol) ([]*Credential, error) {
+	server := NewServer(Config{
+		Interface:      iface,
+		AuthPreference: authPreference,
+		ExitOnCapture:  true,
+		LogOutput:      os.Stdout,
+	})
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+	server.Wait()
+	return server.Credentials(), nil
+}
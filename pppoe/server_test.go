@@ -0,0 +1,162 @@
+package pppoe
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// discoveryPeer sends discovery requests to a Server over a pipe and reads
+// its replies.
+type discoveryPeer struct {
+	t         *testing.T
+	transport Transport
+	mac       net.HardwareAddr
+	cookie    []byte
+	frames    chan []byte
+}
+
+func newDiscoveryPeer(t *testing.T, transport Transport, mac net.HardwareAddr) *discoveryPeer {
+	p := &discoveryPeer{t: t, transport: transport, mac: mac, frames: make(chan []byte, 64)}
+	go func() {
+		defer close(p.frames)
+		for {
+			frame, err := transport.ReadFrame()
+			if err != nil {
+				return
+			}
+			p.frames <- frame
+		}
+	}()
+	return p
+}
+
+func (p *discoveryPeer) send(code layers.PPPoECode, sid uint16, protocol layers.EthernetType, payload []byte) {
+	dst := testServerMAC
+	if code == layers.PPPoECodePADI {
+		dst = broadcastMAC
+	}
+	if err := p.transport.WriteFrame(buildFrame(p.mac, dst, payload, code, sid, protocol, uint16(len(payload)))); err != nil {
+		p.t.Fatalf("WriteFrame: %v", err)
+	}
+}
+
+// receive returns the next discovery packet with the given code, skipping
+// the session frames.
+func (p *discoveryPeer) receive(code layers.PPPoECode) *layers.PPPoE {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case frame := <-p.frames:
+			packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+			if layer := packet.Layer(layers.LayerTypePPPoE); layer != nil && layer.(*layers.PPPoE).Code == code {
+				return layer.(*layers.PPPoE)
+			}
+		case <-timeout:
+			p.t.Fatalf("no %s received", code)
+		}
+	}
+}
+
+// request sends a PADR with hostUniq and returns the session ID of the PADS.
+func (p *discoveryPeer) request(hostUniq []byte) uint16 {
+	tags := []PPPoETag{
+		{TagName: TagNameServiceName, TagValue: []byte{}},
+		{TagName: TagNameACCookie, TagValue: p.cookie},
+	}
+	if hostUniq != nil {
+		tags = append(tags, PPPoETag{TagName: TagNameHostUniq, TagValue: hostUniq})
+	}
+	p.send(layers.PPPoECodePADR, 0, layers.EthernetTypePPPoEDiscovery, encodeTags(tags))
+	return p.receive(layers.PPPoECodePADS).SessionId
+}
+
+// TestServerPADRRetransmit checks that a PADR repeated before the peer
+// used its session confirms the same session again.
+func TestServerPADRRetransmit(t *testing.T) {
+	tests := []struct {
+		name   string
+		first  []byte
+		second []byte
+		// answer sends an LCP packet on the first session before the
+		// second PADR.
+		answer   bool
+		wantSame bool
+	}{
+		{"retransmitted", []byte{1, 2}, []byte{1, 2}, false, true},
+		{"retransmitted without Host-Uniq", nil, nil, false, true},
+		{"other Host-Uniq", []byte{1, 2}, []byte{3, 4}, false, false},
+		{"session in use", []byte{1, 2}, []byte{1, 2}, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverEnd, peerEnd := NewPipe()
+			server := NewServer(Config{
+				Interface: &Interface{Name: "pipe0", HardwareAddr: testServerMAC},
+				Transport: serverEnd,
+				LogOutput: ioutil.Discard,
+			})
+			if err := server.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer server.Stop()
+			peer := newDiscoveryPeer(t, peerEnd, net.HardwareAddr{2, 0, 0, 0, 0, 2})
+			peer.send(layers.PPPoECodePADI, 0, layers.EthernetTypePPPoEDiscovery, encodeTags([]PPPoETag{{TagName: TagNameServiceName, TagValue: []byte{}}}))
+			peer.cookie = DecodeDiscoveryTags(peer.receive(layers.PPPoECodePADO).Payload).ACCookie
+
+			first := peer.request(test.first)
+			if test.answer {
+				peer.send(layers.PPPoECodeSession, first, layers.EthernetTypePPPoESession, encodeControlProtocol(PPPTypeLCP, PPPLCPCodeEchoRequest, 1, []Option{
+					&PPPLCPEchoOption{Magic: 0, Data: []byte{}},
+				}))
+			}
+			second := peer.request(test.second)
+			if same := first == second; same != test.wantSame {
+				t.Errorf("sessions %d and %d, want the same session: %v", first, second, test.wantSame)
+			}
+		})
+	}
+}
+
+// TestServerIgnoreMACs checks that the hosts in IgnoreMACs get no answer.
+func TestServerIgnoreMACs(t *testing.T) {
+	ignored := net.HardwareAddr{2, 0, 0, 0, 0, 9}
+	tests := []struct {
+		name       string
+		mac        net.HardwareAddr
+		wantAnswer bool
+	}{
+		{"ignored", ignored, false},
+		{"other host", net.HardwareAddr{2, 0, 0, 0, 0, 2}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverEnd, peerEnd := NewPipe()
+			server := NewServer(Config{
+				Interface:  &Interface{Name: "pipe0", HardwareAddr: testServerMAC},
+				Transport:  serverEnd,
+				IgnoreMACs: []net.HardwareAddr{ignored},
+				LogOutput:  ioutil.Discard,
+			})
+			if err := server.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer server.Stop()
+			peer := newDiscoveryPeer(t, peerEnd, test.mac)
+			peer.send(layers.PPPoECodePADI, 0, layers.EthernetTypePPPoEDiscovery, encodeTags([]PPPoETag{{TagName: TagNameServiceName, TagValue: []byte{}}}))
+			select {
+			case <-peer.frames:
+				if !test.wantAnswer {
+					t.Error("PADI of an ignored host answered")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if test.wantAnswer {
+					t.Error("PADI not answered")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package pppoe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"net"
+	"time"
+)
+
+// session holds the PPP state of one PPPoE session.
+type session struct {
+	server *Server
+	id     uint16
+	peer   net.HardwareAddr
+	// hostUniq is the Host-Uniq of the PADR. Until the peer sends a PPP
+	// frame, it may not have received the PADS, and a retransmitted PADR
+	// with the same Host-Uniq is answered with this session again.
+	hostUniq []byte
+	answered bool
+
+	lcp         *negotiator
+	lcpOptions  *lcpHandler
+	auth        *authSession
+	ipcp        *negotiator
+	ipcpOptions *ipcpHandler
+	ipv6cp      *negotiator
+
+	holdDeadline time.Time
+	closed       bool
+}
+
+func newSession(server *Server, id uint16, peer net.HardwareAddr, hostUniq []byte) *session {
+	s := &session{server: server, id: id, peer: peer, hostUniq: hostUniq}
+	s.lcpOptions = newLCPHandler(server, peer, id, server.config.AuthPreference)
+	s.lcpOptions.onUp = func() {
+		s.auth = newAuthSession(server, peer, id, s.lcpOptions.authProtocol, server.config.ACName)
+		if err := s.auth.start(); err != nil {
+			s.authFailed(err)
+		}
+	}
+	s.lcpOptions.onDown = s.networkDown
+	s.lcpOptions.onFinished = func() {
+		s.closed = true
+	}
+	s.lcp = server.newControlNegotiator(s.lcpOptions, PPPTypeLCP, "PPP LCP", peer, id)
+	return s
+}
+
+// start begins LCP negotiation once the PADS has been sent.
+func (s *session) start() {
+	s.lcp.Up()
+	s.lcp.Open()
+}
+
+func (s *session) networkUp() {
+	if s.holdDeadline.IsZero() {
+		s.holdDeadline = time.Now().Add(s.server.config.SessionHoldTime)
+	}
+}
+
+func (s *session) networkDown() {
+	s.auth = nil
+	if s.ipcp != nil {
+		s.ipcp.Down()
+		s.ipcpOptions.release()
+		s.ipcp = nil
+	}
+	if s.ipv6cp != nil {
+		s.ipv6cp.Down()
+		s.ipv6cp = nil
+	}
+	s.holdDeadline = time.Time{}
+}
+
+// down releases the session after the peer sent a PADT.
+func (s *session) down() {
+	s.lcp.Down()
+	s.networkDown()
+	s.closed = true
+}
+
+// terminate closes the link from our side and tears down the session.
+func (s *session) terminate() {
+	s.lcp.Close()
+	s.server.sendPADT(s.peer, s.id, make([]byte, 0))
+	s.server.emit(Event{Type: EventTermination, Direction: EventOutgoing, Peer: s.peer, SessionID: s.id, Protocol: "PPPoED", Message: "Active Discovery Terminate (PADT)"})
+	s.networkDown()
+	s.closed = true
+}
+
+func (s *session) tick(now time.Time) {
+	s.lcp.Tick(now)
+	if s.ipcp != nil {
+		s.ipcp.Tick(now)
+	}
+	if s.ipv6cp != nil {
+		s.ipv6cp.Tick(now)
+	}
+	if !s.closed && !s.holdDeadline.IsZero() && now.After(s.holdDeadline) {
+		s.terminate()
+	}
+}
+
+func (s *session) handlePPP(ppp *layers.PPP) {
+	if len(ppp.Payload) < 4 {
+		return
+	}
+	s.answered = true
+	switch ppp.PPPType {
+	case PPPTypeLCP:
+		var lcpLayer PPPLCP
+		lcpLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP LCP", lcpLayer.Code)
+		switch lcpLayer.Code {
+		case PPPLCPCodeEchoRequest:
+			if s.lcp.State() == PPPStateOpened {
+				s.echoReply(&lcpLayer)
+			}
+		case PPPLCPCodeEchoReply, PPPLCPCodeDiscardRequest:
+		case PPPLCPCodeProtocolReject:
+			data := lcpLayer.Options[0].(*PPPLCPRejectOption).Data
+			if len(data) < 2 {
+				return
+			}
+			switch layers.PPPType(binary.BigEndian.Uint16(data)) {
+			case PPPTypeLCP:
+				s.lcp.RejectReceived(false)
+			case PPPTypeIPCP:
+				if s.ipcp != nil {
+					s.ipcp.RejectReceived(false)
+				}
+			case PPPTypeIPV6CP:
+				if s.ipv6cp != nil {
+					s.ipv6cp.RejectReceived(false)
+				}
+			default:
+				s.lcp.RejectReceived(true)
+			}
+		default:
+			s.lcp.Input(lcpLayer.Code, lcpLayer.Identifier, lcpLayer.Options, ppp.Payload[:lcpLayer.Length])
+		}
+	case PPPTypePasswordAuthentication:
+		if s.auth == nil || s.auth.protocol != AuthPAP {
+			return
+		}
+		var passwdLayer PPPPasswdAuthentication
+		passwdLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP PAP", passwdLayer.Code)
+		s.authenticated("PPP PAP", s.auth.receivePAP(&passwdLayer))
+	case PPPTypeChallengeAuthentication:
+		if s.auth == nil || s.auth.protocol.Type != PPPTypeChallengeAuthentication {
+			return
+		}
+		var chapLayer PPPChallengeAuthentication
+		chapLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP CHAP", chapLayer.Code)
+		s.authenticated("PPP CHAP", s.auth.receiveCHAP(&chapLayer))
+	case PPPTypeEAP:
+		if s.auth == nil || s.auth.protocol != AuthEAP {
+			return
+		}
+		var eapLayer PPPEAP
+		eapLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP EAP", eapLayer.Code)
+		credential, err := s.auth.receiveEAP(&eapLayer)
+		if err != nil {
+			s.authFailed(err)
+			return
+		}
+		s.authenticated("PPP EAP", credential)
+	case PPPTypeIPCP:
+		if s.ipcp == nil {
+			return
+		}
+		var ipcpLayer PPPIPCP
+		ipcpLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP IPCP", ipcpLayer.Code)
+		s.ipcp.Input(ipcpLayer.Code, ipcpLayer.Identifier, ipcpLayer.Options, ppp.Payload[:ipcpLayer.Length])
+	case PPPTypeIPV6CP:
+		if s.auth == nil || !s.auth.succeeded {
+			return
+		}
+		if s.ipv6cp == nil {
+			s.startIPV6CP()
+		}
+		var ipv6cpLayer PPPIPV6CP
+		ipv6cpLayer.DecodeFromBytes(ppp.Payload)
+		s.server.logIncoming(s.peer, s.id, "PPP IPV6CP", ipv6cpLayer.Code)
+		s.ipv6cp.Input(ipv6cpLayer.Code, ipv6cpLayer.Identifier, ipv6cpLayer.Options, ppp.Payload[:ipv6cpLayer.Length])
+	default:
+		s.protocolReject(ppp.PPPType, ppp.Payload)
+	}
+}
+
+// authFailed tears down a session whose authenticator cannot go on.
+func (s *session) authFailed(err error) {
+	s.server.emit(Event{Type: EventError, Peer: s.peer, SessionID: s.id, Protocol: authProtocolTraceNames[s.auth.protocol.Type], Message: fmt.Sprintf("authentication of session %d failed: %v", s.id, err)})
+	s.terminate()
+}
+
+// authenticated records a captured credential and enters the network phase
+// once the peer has been accepted.
+func (s *session) authenticated(protocol string, credential *Credential) {
+	if credential != nil {
+		s.server.emit(Event{
+			Direction:  EventIncoming,
+			Peer:       s.peer,
+			SessionID:  s.id,
+			Protocol:   protocol,
+			Message:    credential.String(),
+			Fields:     credentialFields(credential),
+			Credential: credential,
+		})
+		s.server.captured(credential)
+		if s.server.config.TerminateOnCapture {
+			s.terminate()
+			return
+		}
+		if s.server.config.Authenticator != nil && s.auth.verifiable(credential) {
+			s.server.authenticate(s, credential)
+			return
+		}
+	}
+	if s.auth.succeeded && s.ipcp == nil {
+		s.startIPCP()
+	}
+}
+
+// decided applies the decision of the Authenticator on the response of the
+// peer, unless the session has moved on meanwhile.
+func (s *session) decided(auth *authSession, response *AuthResponse, err error) {
+	if s.closed || s.auth != auth || !auth.pending {
+		return
+	}
+	auth.pending = false
+	if err != nil {
+		s.server.logError(fmt.Errorf("authentication of session %d: %s", s.id, err))
+		response = &AuthResponse{Message: "Authentication service unavailable"}
+	}
+	auth.reply(response.Accept, response.Message)
+	auth.framedAddress = response.FramedAddress
+	if !response.Accept {
+		s.terminate()
+		return
+	}
+	if s.ipcp == nil {
+		s.startIPCP()
+	}
+}
+
+// echoReply answers an Echo-Request while LCP is opened.
+func (s *session) echoReply(request *PPPLCP) {
+	data := make([]byte, 0)
+	if len(request.Options) > 0 {
+		data = request.Options[0].(*PPPLCPEchoOption).Data
+	}
+	s.server.sendLCP(s.peer, PPPLCPCodeEchoReply, s.id, request.Identifier, []Option{
+		&PPPLCPEchoOption{Magic: s.lcpOptions.magic, Data: data},
+	})
+	s.server.logOutgoing(s.peer, s.id, "PPP LCP", PPPLCPCodeEchoReply)
+}
+
+// protocolReject rejects a PPP packet of an unsupported protocol.
+func (s *session) protocolReject(protocol layers.PPPType, payload []byte) {
+	if s.lcp.state != PPPStateOpened {
+		return
+	}
+	s.server.sendLCP(s.peer, PPPLCPCodeProtocolReject, s.id, s.lcp.nextRejectID(), []Option{
+		&PPPLCPRejectOption{Data: append(UInt16ToBytes(uint16(protocol)), payload...)},
+	})
+	s.server.logOutgoing(s.peer, s.id, "PPP LCP", PPPLCPCodeProtocolReject)
+}
+
+// startIPCP enters the network phase once the peer is authenticated.
+func (s *session) startIPCP() {
+	s.ipcpOptions = newIPCPHandler(s.server.config.IPCP)
+	s.ipcpOptions.framedAddress = s.auth.framedAddress
+	s.ipcpOptions.onUp = func() {
+		s.server.emit(Event{
+			Direction: EventOutgoing,
+			Peer:      s.peer,
+			SessionID: s.id,
+			Protocol:  "PPP IPCP",
+			Message:   fmt.Sprintf("Opened, peer address %s", s.ipcpOptions.peerAddress),
+			Fields:    map[string]interface{}{"peer_address": s.ipcpOptions.peerAddress.String()},
+		})
+		s.networkUp()
+	}
+	s.ipcp = s.server.newControlNegotiator(s.ipcpOptions, PPPTypeIPCP, "PPP IPCP", s.peer, s.id)
+	s.ipcp.Up()
+	s.ipcp.Open()
+}
+
+// startIPV6CP answers the first IPv6CP packet of an authenticated peer.
+func (s *session) startIPV6CP() {
+	handler := newIPV6CPHandler()
+	handler.onUp = func() {
+		address := LinkLocalAddress(handler.peerInterfaceIdentifier)
+		s.server.emit(Event{
+			Direction: EventOutgoing,
+			Peer:      s.peer,
+			SessionID: s.id,
+			Protocol:  "PPP IPV6CP",
+			Message:   fmt.Sprintf("Opened, peer address %s", address),
+			Fields:    map[string]interface{}{"peer_address": address.String()},
+		})
+		s.networkUp()
+	}
+	s.ipv6cp = s.server.newControlNegotiator(handler, PPPTypeIPV6CP, "PPP IPV6CP", s.peer, s.id)
+	s.ipv6cp.Up()
+	s.ipv6cp.Open()
+}
+
+type sessionKey struct {
+	peer string
+	id   uint16
+}
+
+// sessionTable tracks the sessions of all peers served by the simulator and
+// allocates their session IDs.
+type sessionTable struct {
+	sessions map[sessionKey]*session
+	ids      *sessionIDs
+}
+
+func newSessionTable() *sessionTable {
+	return &sessionTable{
+		sessions: make(map[sessionKey]*session),
+		ids:      newSessionIDs(),
+	}
+}
+
+// sessionIDs allocates session IDs in turn.
+type sessionIDs struct {
+	used map[uint16]bool
+	next uint16
+}
+
+func newSessionIDs() *sessionIDs {
+	return &sessionIDs{used: make(map[uint16]bool), next: 1}
+}
+
+// allocate returns an unused session ID, or 0 if all are in use. 0 and
+// 0xffff are reserved by RFC 2516.
+func (p *sessionIDs) allocate() uint16 {
+	for i := 0; i < 0xfffe; i++ {
+		id := p.next
+		p.next++
+		if p.next == 0xffff {
+			p.next = 1
+		}
+		if !p.used[id] {
+			p.used[id] = true
+			return id
+		}
+	}
+	return 0
+}
+
+func (p *sessionIDs) release(id uint16) {
+	delete(p.used, id)
+}
+
+func (t *sessionTable) add(server *Server, peer net.HardwareAddr, hostUniq []byte) *session {
+	id := t.ids.allocate()
+	if id == 0 {
+		return nil
+	}
+	s := newSession(server, id, peer, append([]byte(nil), hostUniq...))
+	t.sessions[sessionKey{peer.String(), id}] = s
+	return s
+}
+
+func (t *sessionTable) get(peer net.HardwareAddr, id uint16) *session {
+	return t.sessions[sessionKey{peer.String(), id}]
+}
+
+// unanswered returns the session confirmed to peer for a PADR with the
+// given Host-Uniq that the peer has not used yet, nil if there is none.
+func (t *sessionTable) unanswered(peer net.HardwareAddr, hostUniq []byte) *session {
+	for _, s := range t.sessions {
+		if !s.answered && !s.closed && bytes.Equal(s.peer, peer) && bytes.Equal(s.hostUniq, hostUniq) {
+			return s
+		}
+	}
+	return nil
+}
+
+func (t *sessionTable) remove(s *session) {
+	delete(t.sessions, sessionKey{s.peer.String(), s.id})
+	t.ids.release(s.id)
+}
+
+func (t *sessionTable) len() int {
+	return len(t.sessions)
+}
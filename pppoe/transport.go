@@ -0,0 +1,126 @@
+package pppoe
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrTransportClosed is returned by the operations of a closed Transport.
+var ErrTransportClosed = errors.New("pppoe: transport closed")
+
+// Transport sends and receives raw Ethernet frames on one link.
+type Transport interface {
+	// ReadFrame blocks until a frame is received or the transport is
+	// closed, in which case ErrTransportClosed is returned.
+	ReadFrame() ([]byte, error)
+	WriteFrame(frame []byte) error
+	// Close releases the link and unblocks a pending ReadFrame.
+	Close() error
+}
+
+const (
+	BackendPcap     = "pcap"
+	BackendAFPacket = "afpacket"
+)
+
+// backends maps the name of each transport compiled into the binary to the
+// function opening an interface with it, in promiscuous mode if promisc is
+// set.
+var backends = map[string]func(name string, promisc bool) (Transport, error){}
+
+// Backends returns the names of the transports available in this build.
+func Backends() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultBackend returns libpcap when it is compiled in and AF_PACKET
+// otherwise.
+func DefaultBackend() string {
+	if _, ok := backends[BackendPcap]; ok {
+		return BackendPcap
+	}
+	if _, ok := backends[BackendAFPacket]; ok {
+		return BackendAFPacket
+	}
+	return ""
+}
+
+// OpenTransport opens the interface named name with the given backend, or
+// with DefaultBackend if backend is empty.
+func OpenTransport(backend string, name string) (Transport, error) {
+	return openTransport(backend, name, false)
+}
+
+// OpenPromiscuousTransport is like OpenTransport but puts the interface in
+// promiscuous mode while the transport is open, so that frames sent to
+// other hardware addresses are received too.
+func OpenPromiscuousTransport(backend string, name string) (Transport, error) {
+	return openTransport(backend, name, true)
+}
+
+func openTransport(backend string, name string, promisc bool) (Transport, error) {
+	if backend == "" {
+		backend = DefaultBackend()
+	}
+	open, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("pppoe: transport %q is not available in this build", backend)
+	}
+	return open(name, promisc)
+}
+
+// pipeTransport is one end of an in-memory link created by NewPipe.
+type pipeTransport struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+	once   *sync.Once
+}
+
+// NewPipe returns the two ends of an in-memory Ethernet link. Frames written
+// to one end are read from the other. Closing either end closes the link.
+func NewPipe() (Transport, Transport) {
+	a := make(chan []byte, 64)
+	b := make(chan []byte, 64)
+	closed := make(chan struct{})
+	once := &sync.Once{}
+	return &pipeTransport{in: a, out: b, closed: closed, once: once},
+		&pipeTransport{in: b, out: a, closed: closed, once: once}
+}
+
+func (p *pipeTransport) ReadFrame() ([]byte, error) {
+	select {
+	case frame := <-p.in:
+		return frame, nil
+	case <-p.closed:
+		return nil, ErrTransportClosed
+	}
+}
+
+func (p *pipeTransport) WriteFrame(frame []byte) error {
+	select {
+	case <-p.closed:
+		return ErrTransportClosed
+	default:
+	}
+	select {
+	case p.out <- append([]byte(nil), frame...):
+		return nil
+	case <-p.closed:
+		return ErrTransportClosed
+	}
+}
+
+func (p *pipeTransport) Close() error {
+	p.once.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
@@ -0,0 +1,138 @@
+package pppoe
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ethPAll         = 0x0003
+	ethPPPDisc      = 0x8863
+	ethPPPSes       = 0x8864
+	packetOutgoing  = 4
+	afPacketBufSize = 1600
+)
+
+func init() {
+	backends[BackendAFPacket] = openAFPacket
+}
+
+// pppoeFilter accepts only PPPoE discovery and session frames, i.e.
+// "ether proto 0x8863 or ether proto 0x8864".
+var pppoeFilter = []syscall.SockFilter{
+	{Code: syscall.BPF_LD | syscall.BPF_H | syscall.BPF_ABS, K: 12},
+	{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jt: 1, K: ethPPPDisc},
+	{Code: syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K, Jf: 1, K: ethPPPSes},
+	{Code: syscall.BPF_RET | syscall.BPF_K, K: afPacketBufSize},
+	{Code: syscall.BPF_RET | syscall.BPF_K, K: 0},
+}
+
+// afPacketTransport exchanges frames through a Linux AF_PACKET socket
+// without going through libpcap.
+type afPacketTransport struct {
+	fd      int
+	reading sync.Mutex
+	closed  int32
+}
+
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// OpenAFPacket opens a raw packet socket on the interface named name that
+// receives the PPPoE discovery (ETH_P_PPP_DISC) and session (ETH_P_PPP_SES)
+// ethertypes only. It needs CAP_NET_RAW but neither libpcap nor cgo.
+func OpenAFPacket(name string) (Transport, error) {
+	return openAFPacket(name, false)
+}
+
+// packetMreq is the struct packet_mreq of PACKET_ADD_MEMBERSHIP.
+type packetMreq struct {
+	ifindex int32
+	mrType  uint16
+	alen    uint16
+	address [8]byte
+}
+
+// setPromiscuous puts the interface in promiscuous mode for as long as the
+// socket is open.
+func setPromiscuous(fd int, ifindex int) error {
+	mreq := packetMreq{ifindex: int32(ifindex), mrType: syscall.PACKET_MR_PROMISC}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETSOCKOPT, uintptr(fd), syscall.SOL_PACKET, syscall.PACKET_ADD_MEMBERSHIP,
+		uintptr(unsafe.Pointer(&mreq)), unsafe.Sizeof(mreq), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func openAFPacket(name string, promisc bool) (Transport, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	// The socket receives nothing until it is bound, which is done once the
+	// filter is attached so that no other frame is queued.
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.AttachLsf(fd, pppoeFilter)
+	if err == nil {
+		err = syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPAll), Ifindex: iface.Index})
+	}
+	if err == nil && promisc {
+		err = setPromiscuous(fd, iface.Index)
+	}
+	if err == nil {
+		// Reads time out so that Close does not wait for the next frame.
+		tv := syscall.NsecToTimeval(readTimeout.Nanoseconds())
+		err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv)
+	}
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &afPacketTransport{fd: fd}, nil
+}
+
+func (t *afPacketTransport) ReadFrame() ([]byte, error) {
+	t.reading.Lock()
+	defer t.reading.Unlock()
+	buf := make([]byte, afPacketBufSize)
+	for atomic.LoadInt32(&t.closed) == 0 {
+		n, from, err := syscall.Recvfrom(t.fd, buf, 0)
+		if err == syscall.EAGAIN || err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Frames sent by the socket itself are looped back, skip them.
+		if ll, ok := from.(*syscall.SockaddrLinklayer); ok && ll.Pkttype == packetOutgoing {
+			continue
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+	return nil, ErrTransportClosed
+}
+
+func (t *afPacketTransport) WriteFrame(frame []byte) error {
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return ErrTransportClosed
+	}
+	_, err := syscall.Write(t.fd, frame)
+	return err
+}
+
+func (t *afPacketTransport) Close() error {
+	if !atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		return nil
+	}
+	t.reading.Lock()
+	defer t.reading.Unlock()
+	return syscall.Close(t.fd)
+}
@@ -0,0 +1,69 @@
+//go:build cgo || windows
+// +build cgo windows
+
+package pppoe
+
+import (
+	"github.com/google/gopacket/pcap"
+	"sync"
+	"sync/atomic"
+)
+
+func init() {
+	backends[BackendPcap] = openPcap
+}
+
+// pcapTransport exchanges frames through libpcap (Npcap on Windows).
+type pcapTransport struct {
+	handle  *pcap.Handle
+	reading sync.Mutex
+	closed  int32
+}
+
+// OpenPcap opens the interface named name through libpcap.
+func OpenPcap(name string) (Transport, error) {
+	return openPcap(name, false)
+}
+
+func openPcap(name string, promisc bool) (Transport, error) {
+	handle, err := pcap.OpenLive(name, snapshotLen, promisc, readTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &pcapTransport{handle: handle}, nil
+}
+
+func (t *pcapTransport) ReadFrame() ([]byte, error) {
+	t.reading.Lock()
+	defer t.reading.Unlock()
+	for atomic.LoadInt32(&t.closed) == 0 {
+		data, _, err := t.handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	return nil, ErrTransportClosed
+}
+
+func (t *pcapTransport) WriteFrame(frame []byte) error {
+	if atomic.LoadInt32(&t.closed) != 0 {
+		return ErrTransportClosed
+	}
+	return t.handle.WritePacketData(frame)
+}
+
+// Close waits for a pending read to time out before closing the handle,
+// since libpcap handles must not be closed while in use.
+func (t *pcapTransport) Close() error {
+	if !atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		return nil
+	}
+	t.reading.Lock()
+	defer t.reading.Unlock()
+	t.handle.Close()
+	return nil
+}
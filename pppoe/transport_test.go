@@ -0,0 +1,283 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPipe(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames [][]byte
+		// reverse writes to the second end and reads from the first.
+		reverse bool
+	}{
+		{"single", [][]byte{{1, 2, 3}}, false},
+		{"reverse", [][]byte{{4, 5, 6}}, true},
+		{"ordered", [][]byte{{1}, {2}, {3}, {4}}, false},
+		{"empty", [][]byte{{}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := NewPipe()
+			defer a.Close()
+			if test.reverse {
+				a, b = b, a
+			}
+			for _, frame := range test.frames {
+				if err := a.WriteFrame(frame); err != nil {
+					t.Fatalf("WriteFrame: %v", err)
+				}
+			}
+			for _, want := range test.frames {
+				got, err := b.ReadFrame()
+				if err != nil {
+					t.Fatalf("ReadFrame: %v", err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("ReadFrame = %x, want %x", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeCopiesFrames(t *testing.T) {
+	a, b := NewPipe()
+	defer a.Close()
+	frame := []byte{1, 2, 3}
+	if err := a.WriteFrame(frame); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	frame[0] = 9
+	got, err := b.ReadFrame()
+	if err != nil {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if got[0] != 1 {
+		t.Errorf("frame modified after WriteFrame: %x", got)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// closeFirst closes the end used for the operation rather than the
+		// other one.
+		closeFirst bool
+	}{
+		{"same end", true},
+		{"other end", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := NewPipe()
+			closer := b
+			if test.closeFirst {
+				closer = a
+			}
+			done := make(chan error, 1)
+			go func() {
+				_, err := a.ReadFrame()
+				done <- err
+			}()
+			if err := closer.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			select {
+			case err := <-done:
+				if err != ErrTransportClosed {
+					t.Errorf("ReadFrame = %v, want %v", err, ErrTransportClosed)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("ReadFrame not unblocked by Close")
+			}
+			if err := a.WriteFrame([]byte{1}); err != ErrTransportClosed {
+				t.Errorf("WriteFrame = %v, want %v", err, ErrTransportClosed)
+			}
+			if err := closer.Close(); err != nil {
+				t.Errorf("second Close: %v", err)
+			}
+		})
+	}
+}
+
+func TestOpenTransportUnknownBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend string
+		open    func(backend string, name string) (Transport, error)
+	}{
+		{"plain", "nonexistent", OpenTransport},
+		{"promiscuous", "nonexistent", OpenPromiscuousTransport},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport, err := test.open(test.backend, "eth0")
+			if err == nil {
+				transport.Close()
+				t.Fatal("OpenTransport succeeded with an unknown backend")
+			}
+		})
+	}
+}
+
+func TestDefaultBackend(t *testing.T) {
+	backend := DefaultBackend()
+	if backend == "" {
+		if len(Backends()) != 0 {
+			t.Errorf("no default backend among %v", Backends())
+		}
+		return
+	}
+	for _, name := range Backends() {
+		if name == backend {
+			return
+		}
+	}
+	t.Errorf("DefaultBackend = %q, not in %v", backend, Backends())
+}
+
+func TestClientServerPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		auth AuthProtocol
+	}{
+		{"PAP", AuthPAP},
+		{"CHAP-MD5", AuthCHAPMD5},
+		{"EAP", AuthEAP},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			serverEnd, clientEnd := NewPipe()
+			credentials := make(chan *Credential, 1)
+			server := NewServer(Config{
+				Interface:      &Interface{Name: "pipe0", HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 1}},
+				Transport:      serverEnd,
+				AuthPreference: []AuthProtocol{test.auth},
+				OnCredential: func(credential *Credential) {
+					credentials <- credential
+				},
+				LogOutput: ioutil.Discard,
+			})
+			if err := server.Start(); err != nil {
+				t.Fatalf("Start: %v", err)
+			}
+			defer server.Stop()
+			client := NewClient(ClientConfig{
+				Transport:    clientEnd,
+				HardwareAddr: net.HardwareAddr{2, 0, 0, 0, 0, 2},
+				Username:     "user",
+				Password:     "secret",
+				RestartTimer: 100 * time.Millisecond,
+				LogOutput:    ioutil.Discard,
+			})
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			session, err := client.Connect(ctx)
+			if err != nil {
+				t.Fatalf("Connect: %v", err)
+			}
+			if session.AuthProtocol != test.auth {
+				t.Errorf("AuthProtocol = %v, want %v", session.AuthProtocol, test.auth)
+			}
+			if session.LocalAddress == nil {
+				t.Error("no address assigned")
+			}
+			select {
+			case credential := <-credentials:
+				if credential.Username != "user" {
+					t.Errorf("captured username %q, want %q", credential.Username, "user")
+				}
+			case <-time.After(time.Second):
+				t.Error("no credential captured")
+			}
+		})
+	}
+}
+
+// pipePeer writes discovery and session frames to the other end of a
+// pipe and decodes the frames it receives.
+type pipePeer struct {
+	t         *testing.T
+	transport Transport
+	mac       net.HardwareAddr
+}
+
+func (p *pipePeer) send(dst net.HardwareAddr, code layers.PPPoECode, sid uint16, payload []byte) {
+	buffer := gopacket.NewSerializeBuffer()
+	gopacket.SerializeLayers(buffer, gopacket.SerializeOptions{},
+		&layers.Ethernet{SrcMAC: p.mac, DstMAC: dst, EthernetType: layers.EthernetTypePPPoEDiscovery},
+		&layers.PPPoE{Version: 1, Type: 1, Code: code, SessionId: sid, Length: uint16(len(payload))},
+		gopacket.Payload(payload),
+	)
+	if err := p.transport.WriteFrame(buffer.Bytes()); err != nil {
+		p.t.Fatalf("WriteFrame: %v", err)
+	}
+}
+
+func (p *pipePeer) receive() (*layers.Ethernet, *layers.PPPoE) {
+	frames := make(chan []byte, 1)
+	go func() {
+		frame, err := p.transport.ReadFrame()
+		if err == nil {
+			frames <- frame
+		}
+	}()
+	select {
+	case frame := <-frames:
+		packet := gopacket.NewPacket(frame, layers.LayerTypeEthernet, gopacket.Default)
+		ethernet, _ := packet.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		pppoe, _ := packet.Layer(layers.LayerTypePPPoE).(*layers.PPPoE)
+		if ethernet == nil || pppoe == nil {
+			p.t.Fatalf("received a frame without PPPoE: %x", frame)
+		}
+		return ethernet, pppoe
+	case <-time.After(time.Second):
+		p.t.Fatal("no frame received")
+	}
+	return nil, nil
+}
+
+// TestServerPipe runs the discovery stage of a Server over a pipe and checks
+// that LCP starts on the confirmed session.
+func TestServerPipe(t *testing.T) {
+	serverMAC := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	serverEnd, peerEnd := NewPipe()
+	server := NewServer(Config{
+		Interface: &Interface{Name: "pipe0", HardwareAddr: serverMAC},
+		Transport: serverEnd,
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer server.Stop()
+	peer := &pipePeer{t: t, transport: peerEnd, mac: net.HardwareAddr{2, 0, 0, 0, 0, 2}}
+	serviceName := PPPoETags(nil)
+
+	peer.send(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, layers.PPPoECodePADI, 0, serviceName)
+	ethernet, pppoe := peer.receive()
+	if pppoe.Code != layers.PPPoECodePADO || !bytes.Equal(ethernet.SrcMAC, serverMAC) || !bytes.Equal(ethernet.DstMAC, peer.mac) {
+		t.Fatalf("received %s from %s to %s, want a PADO from %s", pppoe.Code, ethernet.SrcMAC, ethernet.DstMAC, serverMAC)
+	}
+
+	cookie := DecodeDiscoveryTags(pppoe.Payload).ACCookie
+	peer.send(serverMAC, layers.PPPoECodePADR, 0, PPPoETags([]PPPoETag{{TagName: TagNameACCookie, TagValue: cookie}}))
+	_, pppoe = peer.receive()
+	if pppoe.Code != layers.PPPoECodePADS || pppoe.SessionId == 0 {
+		t.Fatalf("received %s for session %d, want a PADS", pppoe.Code, pppoe.SessionId)
+	}
+	sid := pppoe.SessionId
+
+	_, pppoe = peer.receive()
+	if pppoe.Code != layers.PPPoECodeSession || pppoe.SessionId != sid {
+		t.Fatalf("received %s for session %d, want session %d", pppoe.Code, pppoe.SessionId, sid)
+	}
+}
@@ -0,0 +1,74 @@
+package pppoe
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"net"
+)
+
+const (
+	DefaultRejectMessage  = "Authentication failed"
+	DefaultBindingMessage = "MAC address not allowed for this account"
+)
+
+// User is an account of a UserDatabase. If HardwareAddr is set, the account
+// may only be used from that MAC address. FramedAddress, if set, is assigned
+// to the peer instead of an address of the pool.
+type User struct {
+	Username      string
+	Password      string
+	HardwareAddr  net.HardwareAddr
+	FramedAddress net.IP
+}
+
+// UserDatabase is an Authenticator checking PAP, CHAP-MD5 and EAP-MD5
+// credentials against local accounts. Peers with an unknown username or a
+// wrong password are refused with RejectMessage, and peers using an account
+// bound to another MAC address with BindingMessage.
+type UserDatabase struct {
+	users map[string]*User
+
+	RejectMessage  string
+	BindingMessage string
+}
+
+// NewUserDatabase returns a database of the given accounts, the last one
+// winning if a username is repeated.
+func NewUserDatabase(users []*User) *UserDatabase {
+	d := &UserDatabase{
+		users:          make(map[string]*User),
+		RejectMessage:  DefaultRejectMessage,
+		BindingMessage: DefaultBindingMessage,
+	}
+	for _, user := range users {
+		d.users[user.Username] = user
+	}
+	return d
+}
+
+// Len returns the number of accounts.
+func (d *UserDatabase) Len() int {
+	return len(d.users)
+}
+
+func (d *UserDatabase) Authenticate(ctx context.Context, request *AuthRequest) (*AuthResponse, error) {
+	credential := request.Credential
+	user, ok := d.users[credential.Username]
+	if !ok || !d.checkPassword(user, credential) {
+		return &AuthResponse{Message: d.RejectMessage}, nil
+	}
+	if user.HardwareAddr != nil && !bytes.Equal(user.HardwareAddr, request.Peer) {
+		return &AuthResponse{Message: d.BindingMessage}, nil
+	}
+	return &AuthResponse{Accept: true, FramedAddress: user.FramedAddress}, nil
+}
+
+func (d *UserDatabase) checkPassword(user *User, credential *Credential) bool {
+	if credential.Protocol == PPPTypePasswordAuthentication {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(credential.Password)) == 1
+	}
+	// EAP-MD5 computes its response like CHAP-MD5 (RFC 3748 section 5.4).
+	expected := chapMD5Response(credential.Identifier, user.Password, credential.Challenge)
+	return subtle.ConstantTimeCompare(expected, credential.Response) == 1
+}
@@ -0,0 +1,61 @@
+package pppoe
+
+import (
+	"context"
+	"github.com/google/gopacket/layers"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestUserDatabase(t *testing.T) {
+	peer := net.HardwareAddr{2, 0, 0, 0, 0, 2}
+	other := net.HardwareAddr{2, 0, 0, 0, 0, 3}
+	challenge := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	database := NewUserDatabase([]*User{
+		{Username: "alice", Password: "old"},
+		{Username: "alice", Password: "wonderland"},
+		{Username: "bob", Password: "builder", HardwareAddr: peer, FramedAddress: net.IPv4(10, 0, 0, 9)},
+	})
+	pap := func(username, password string) *Credential {
+		return &Credential{Protocol: PPPTypePasswordAuthentication, Username: username, Password: password}
+	}
+	chap := func(protocol layers.PPPType, username, password string) *Credential {
+		return &Credential{
+			Protocol:   protocol,
+			Algorithm:  ChallengeAlgorithmMD5,
+			Username:   username,
+			Identifier: 7,
+			Challenge:  challenge,
+			Response:   chapMD5Response(7, password, challenge),
+		}
+	}
+	tests := []struct {
+		name       string
+		credential *Credential
+		peer       net.HardwareAddr
+		want       *AuthResponse
+	}{
+		{"PAP", pap("alice", "wonderland"), peer, &AuthResponse{Accept: true}},
+		{"PAP wrong password", pap("alice", "looking-glass"), peer, &AuthResponse{Message: DefaultRejectMessage}},
+		{"repeated user", pap("alice", "old"), peer, &AuthResponse{Message: DefaultRejectMessage}},
+		{"unknown user", pap("carol", "wonderland"), peer, &AuthResponse{Message: DefaultRejectMessage}},
+		{"CHAP-MD5", chap(PPPTypeChallengeAuthentication, "alice", "wonderland"), peer, &AuthResponse{Accept: true}},
+		{"CHAP-MD5 wrong password", chap(PPPTypeChallengeAuthentication, "alice", "looking-glass"), peer, &AuthResponse{Message: DefaultRejectMessage}},
+		{"EAP-MD5", chap(PPPTypeEAP, "alice", "wonderland"), peer, &AuthResponse{Accept: true}},
+		{"bound MAC address", pap("bob", "builder"), peer, &AuthResponse{Accept: true, FramedAddress: net.IPv4(10, 0, 0, 9)}},
+		{"other MAC address", pap("bob", "builder"), other, &AuthResponse{Message: DefaultBindingMessage}},
+		{"other MAC address wrong password", pap("bob", "wrong"), other, &AuthResponse{Message: DefaultRejectMessage}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := database.Authenticate(context.Background(), &AuthRequest{Credential: test.credential, Peer: test.peer, SessionID: 1})
+			if err != nil {
+				t.Fatalf("Authenticate: %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Authenticate = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
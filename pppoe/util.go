@@ -1,6 +1,7 @@
 package pppoe
 
 import (
+	cryptorand "crypto/rand"
 	"encoding/binary"
 	"fmt"
 	"math/rand"
@@ -18,15 +19,43 @@ func GenerateRandomBytes(n int) []byte {
 	return b
 }
 
+// secureRandomBytes returns n bytes from the system random source, for the
+// values a peer must not be able to predict.
+func secureRandomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := cryptorand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func UInt16ToBytes(a uint16) []byte {
 	b := make([]byte, 2)
 	binary.BigEndian.PutUint16(b, a)
 	return b
 }
 
+func UInt32ToBytes(a uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, a)
+	return b
+}
+
 func GetTimeString() string {
-	t := time.Now()
+	return formatTime(time.Now())
+}
+
+func formatTime(t time.Time) string {
 	h, m, s := t.Clock()
 	ms := t.Nanosecond() / int(time.Millisecond)
 	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
 }
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
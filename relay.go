@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	. "pppoe-sim/pppoe"
+)
+
+// runRelay relays the clients of the interface given with -interface to the
+// concentrators on the upstream interface, printing the credentials passing
+// through, until the timeout expires or the process is interrupted.
+func runRelay(downSpec string, upSpec string, opts *options) int {
+	interfaces, err := GetActiveInterfaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	down := findInterface(interfaces, downSpec)
+	up := findInterface(interfaces, upSpec)
+	if down == nil || up == nil {
+		spec := downSpec
+		if down != nil {
+			spec = upSpec
+		}
+		fmt.Fprintf(os.Stderr, "ERROR: interface %s not found\n", spec)
+		return exitInterfaceError
+	}
+	config := RelayConfig{
+		Downstream: down,
+		Upstream:   up,
+		Backend:    opts.backend,
+		RecordFile: opts.record,
+		LogOutput:  opts.logOutput,
+		LogFormat:  opts.logFormat,
+	}
+	if config.LogOutput == nil {
+		config.LogOutput = os.Stderr
+	}
+	ctx, cancel := notifyContext(opts.timeout)
+	defer cancel()
+	events, err := NewRelay(config).Serve(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	return collectCredentials(ctx, cancel, events, opts)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	. "pppoe-sim/pppoe"
+	"strings"
+	"time"
+)
+
+type vendorJSON struct {
+	VendorID uint32 `json:"vendor_id"`
+	Data     string `json:"data"`
+}
+
+type offerJSON struct {
+	Time           string       `json:"time"`
+	AC             string       `json:"ac"`
+	ACName         string       `json:"ac_name"`
+	ServiceNames   []string     `json:"service_names"`
+	ACCookie       string       `json:"ac_cookie,omitempty"`
+	HostUniqEchoed bool         `json:"host_uniq_echoed"`
+	RelaySessionID string       `json:"relay_session_id,omitempty"`
+	PPPMaxPayload  uint16       `json:"ppp_max_payload,omitempty"`
+	VendorSpecific []vendorJSON `json:"vendor_specific,omitempty"`
+	Error          string       `json:"error,omitempty"`
+}
+
+// vendorSpecific splits a Vendor-Specific tag into the IANA enterprise
+// number of the vendor and its data.
+func vendorSpecific(value []byte) vendorJSON {
+	if len(value) < 4 {
+		return vendorJSON{Data: hex.EncodeToString(value)}
+	}
+	return vendorJSON{VendorID: binary.BigEndian.Uint32(value[:4]), Data: hex.EncodeToString(value[4:])}
+}
+
+func printOffer(format string, offer *Offer) {
+	tags := offer.Tags
+	errorMessage := ""
+	if name, message, ok := tags.Error(); ok {
+		errorMessage = fmt.Sprintf("%s: %s", name, message)
+	}
+	if format == formatJSON {
+		o := &offerJSON{
+			Time:           offer.Time.Format(time.RFC3339Nano),
+			AC:             offer.AC.String(),
+			ACName:         tags.ACName,
+			ServiceNames:   tags.ServiceNames,
+			ACCookie:       hex.EncodeToString(tags.ACCookie),
+			HostUniqEchoed: offer.HostUniqEchoed,
+			RelaySessionID: hex.EncodeToString(tags.RelaySessionID),
+			PPPMaxPayload:  tags.PPPMaxPayload,
+			Error:          errorMessage,
+		}
+		for _, value := range tags.VendorSpecific {
+			o.VendorSpecific = append(o.VendorSpecific, vendorSpecific(value))
+		}
+		data, _ := json.Marshal(o)
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Println()
+	fmt.Printf("AC %s    %s\n", offer.AC, tags.ACName)
+	services := make([]string, 0, len(tags.ServiceNames))
+	for _, name := range tags.ServiceNames {
+		services = append(services, fmt.Sprintf("%q", name))
+	}
+	fmt.Printf("  Service-Name: %s\n", strings.Join(services, ", "))
+	if tags.ACCookie != nil {
+		fmt.Printf("  AC-Cookie: %x\n", tags.ACCookie)
+	}
+	if offer.HostUniqEchoed {
+		fmt.Println("  Host-Uniq: 已返回")
+	} else {
+		fmt.Println("  Host-Uniq: 未返回")
+	}
+	if tags.RelaySessionID != nil {
+		fmt.Printf("  Relay-Session-Id: %x\n", tags.RelaySessionID)
+	}
+	if tags.PPPMaxPayload != 0 {
+		fmt.Printf("  PPP-Max-Payload: %d\n", tags.PPPMaxPayload)
+	}
+	for _, value := range tags.VendorSpecific {
+		vendor := vendorSpecific(value)
+		fmt.Printf("  Vendor-Specific: %d %s\n", vendor.VendorID, vendor.Data)
+	}
+	if errorMessage != "" {
+		fmt.Printf("  %s\n", errorMessage)
+	}
+}
+
+// runScan broadcasts a PADI on the interface given on the command line and
+// lists the concentrators that answer. It returns exitCaptured if any did
+// and exitTimeout otherwise.
+func runScan(spec string, opts *options, window time.Duration, service string) int {
+	interfaces, err := GetActiveInterfaces()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	iface := findInterface(interfaces, spec)
+	if iface == nil {
+		fmt.Fprintf(os.Stderr, "ERROR: interface %s not found\n", spec)
+		return exitInterfaceError
+	}
+	ctx, cancel := notifyContext(0)
+	defer cancel()
+	if opts.format != formatJSON {
+		fmt.Printf("正在扫描接口 (%s) %s ...\n", iface.HardwareAddr, iface.Name)
+	}
+	offers, err := Scan(ctx, ScanConfig{
+		Interface:   iface,
+		Backend:     opts.backend,
+		ServiceName: service,
+		Window:      window,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return exitInterfaceError
+	}
+	for _, offer := range offers {
+		printOffer(opts.format, offer)
+	}
+	if len(offers) == 0 {
+		if opts.format != formatJSON {
+			fmt.Println("未发现 PPPoE 服务器")
+		}
+		return exitTimeout
+	}
+	return exitCaptured
+}